@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
@@ -9,6 +8,7 @@ import (
 	"github.com/iann0036/iamlive/iamlivecore"
 	"github.com/ryanjarv/goutil/utils"
 	"github.com/ryanjarv/sqs/cmd/events/entities/tmphack"
+	"github.com/ryanjarv/sqs/input"
 	"github.com/ryanjarv/sqs/types"
 	"io"
 	"io/ioutil"
@@ -21,6 +21,7 @@ import (
 
 type Args struct {
 	Debug bool
+	input.Flags
 }
 
 func main() {
@@ -36,42 +37,40 @@ func main() {
 	}
 
 	flag.BoolVar(&args.Debug, "debug", false, "Enable debug output")
+	args.Flags.Register(flag.CommandLine)
 	flag.Parse()
 
 	if args.Debug {
 		ctx.SetLoggingLevel(utils.DebugLogLevel)
 	}
 
-	if len(flag.Args()) > 1 {
-		ctx.Error.Fatalln("extra arguments detected, did you mean to pass a comma seperated list to -profiles instead?")
-	}
-
-	err := Run(ctx, flag.Arg(0))
+	err := Run(ctx, args, flag.Args())
 	if err != nil {
 		ctx.Error.Fatalln(err)
 	}
 }
 
-func Run(ctx utils.Context, path string) error {
-	var in io.ReadCloser
-
-	if path == "" || path == "-" {
-		in = os.Stdin
-	} else {
-		var err error
-		in, err = os.Open(path)
-		if err != nil {
-			return fmt.Errorf("opening %s: %w", path, err)
+// Run reads events from each of paths, when more than one is given events are merged in eventTime order.
+func Run(ctx utils.Context, args Args, paths []string) error {
+	src, err := args.Open(ctx, paths)
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
+	if q, ok := src.(*input.Queue); ok {
+		q.OnError = func(err error) {
+			ctx.Info.Println("warning:", err)
 		}
 	}
-	ct := NewPrincipals(ctx, in)
+	ct := NewPrincipals(ctx, src)
 
-	var err error
 	go func() {
 		<-ctx.Done()
+		if closer, ok := src.(io.Closer); ok {
+			_ = closer.Close()
+		}
 		err := ct.Close()
 		if err != nil {
-			ctx.Error.Println("run: closing cloudtrail stream: %s")
+			ctx.Error.Printf("run: closing cloudtrail stream: %s\n", err)
 		}
 	}()
 
@@ -82,12 +81,12 @@ func Run(ctx utils.Context, path string) error {
 	return nil
 }
 
-func NewPrincipals(ctx utils.Context, r io.ReadCloser) *Principals {
+func NewPrincipals(ctx utils.Context, src input.Source) *Principals {
 	pipe, writer := io.Pipe()
 
 	c := &Principals{
 		PipeReader:    pipe,
-		in:            bufio.NewReader(r),
+		in:            src,
 		out:           writer,
 		ctx:           ctx,
 		Sessions:      &sync.Map{},
@@ -107,7 +106,7 @@ func NewPrincipals(ctx utils.Context, r io.ReadCloser) *Principals {
 
 type Principals struct {
 	*io.PipeReader
-	in            *bufio.Reader
+	in            input.Source
 	out           *io.PipeWriter
 	ctx           utils.Context
 	Sessions      *sync.Map
@@ -117,17 +116,17 @@ type Principals struct {
 func (c *Principals) run() error {
 	defer c.out.Close()
 
-	scanner := bufio.NewScanner(c.in)
-
-	for scanner.Scan() {
-		line := scanner.Bytes()
-
-		// Skip blank lines
-		if len(line) == 0 {
-			continue
+	for {
+		rec, err := c.in.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading input: %w", err)
 		}
+		line := rec.Event
+
 		var data types.GenericEvent
-		err := json.Unmarshal(line, &data)
+		err = json.Unmarshal(line, &data)
 		if err != nil {
 			c.ctx.Debug.Printf("cloudtrail: failed to unmarshal: %b", line)
 			return fmt.Errorf("failed to unmarshal: %s", err)
@@ -141,6 +140,9 @@ func (c *Principals) run() error {
 		}
 
 		data["__context__"] = c.enrich(event, data)
+		if rec.Envelope != nil {
+			data["envelope"] = rec.Envelope
+		}
 
 		b, err := json.Marshal(data)
 		if err != nil {
@@ -152,12 +154,11 @@ func (c *Principals) run() error {
 		if err != nil {
 			return fmt.Errorf("writing to output: %w", err)
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("reading standard input: %w", err)
+		if err := input.Ack(rec); err != nil {
+			return fmt.Errorf("acknowledging event: %w", err)
+		}
 	}
-	return nil
 }
 
 func (c *Principals) enrich(event types.AssumeRoleEvent, data types.GenericEvent) types.Context {
@@ -1,55 +1,288 @@
-package orig
+package main
 
 import (
-	"github.com/ryanjarv/sqs/cmd/events/orig"
+	"context"
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/ryanjarv/goutil/utils"
+	"github.com/ryanjarv/sqs/input"
+	"github.com/ryanjarv/sqs/schema/aws/logs/awsapicallviacloudtrail"
+	"github.com/ryanjarv/sqs/session"
 	"github.com/ryanjarv/sqs/types"
-	"reflect"
-	"sync"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
 
-var testDate = time.Date(2022, time.April, 1, 1, 1, 1, 1, nil)
+var testDate = time.Date(2022, time.April, 1, 1, 1, 1, 0, time.UTC)
+
+var testUser = types.UserIdentity{
+	Type:        "IAMUser",
+	AccountId:   "111111111111",
+	PrincipalId: "AIDA11111111111111111",
+	Arn:         "arn:aws:iam::111111111111:user/test",
+	UserName:    "test",
+}
+
+// assumeRole returns an AssumeRole event by testUser creating a session with accessKey.
+func assumeRole(id, accessKey string) types.AssumeRoleEvent {
+	return types.AssumeRoleEvent{
+		EventID:            id,
+		EventType:          "AwsApiCall",
+		EventName:          "AssumeRole",
+		EventTime:          testDate,
+		UserIdentity:       testUser,
+		RecipientAccountId: testUser.AccountId,
+		ResponseElements: types.ResponseElements{
+			AssumedRoleUser: &types.AssumeRoleUser{Arn: "arn:aws:sts::111111111111:assumed-role/test/session"},
+			Credentials:     &types.Credentials{AccessKeyId: accessKey, SessionToken: "token-" + accessKey},
+		},
+	}
+}
+
+// use returns an event made with the session created by assumeRole(_, accessKey).
+func use(id, accessKey string) types.AssumeRoleEvent {
+	return types.AssumeRoleEvent{
+		EventID:   id,
+		EventType: "AwsApiCall",
+		EventName: "GetCallerIdentity",
+		EventTime: testDate.Add(time.Minute),
+		UserIdentity: types.UserIdentity{
+			Type:        "AssumedRole",
+			Arn:         "arn:aws:sts::111111111111:assumed-role/test/session",
+			AccessKeyId: accessKey,
+			SessionContext: &awsapicallviacloudtrail.SessionContext{
+				Attributes: awsapicallviacloudtrail.Attributes{CreationDate: testDate},
+			},
+		},
+	}
+}
+
+// newTestCloudTrail returns a CloudTrail that has seen events, without an input or output.
+func newTestCloudTrail(t *testing.T, events ...types.AssumeRoleEvent) *CloudTrail {
+	c := &CloudTrail{
+		ctx:     utils.NewContext(context.Background(), false),
+		Tracker: session.NewTracker(session.DefaultOrphans),
+	}
+	for _, event := range events {
+		if _, err := c.Tracker.Observe(event); err != nil {
+			t.Fatalf("Observe() error = %v", err)
+		}
+	}
+	return c
+}
 
 func TestCloudTrail_enrich(t *testing.T) {
-	type fields struct {
-		Sessions *sync.Map
+	service := types.AssumeRoleEvent{
+		EventName: "AssumeRole",
+		EventTime: testDate,
+		UserIdentity: types.UserIdentity{
+			Type:      "AWSService",
+			InvokedBy: "lambda.amazonaws.com",
+		},
+		ResponseElements: types.ResponseElements{
+			Credentials: &types.Credentials{AccessKeyId: "access-key"},
+		},
 	}
-	type args struct {
+
+	tests := []struct {
+		name  string
 		event types.AssumeRoleEvent
+		want  EnrichedEvent
+	}{
+		{
+			name:  "unknown session",
+			event: service,
+			want:  EnrichedEvent{AssumeRoleEvent: service},
+		},
+		{
+			name:  "known session",
+			event: use("2", "ASIA1111111111111111"),
+			want: EnrichedEvent{
+				AssumeRoleEvent:      use("2", "ASIA1111111111111111"),
+				OriginalUserIdentity: testUser,
+				Confidence:           session.High,
+				SessionKey:           use("2", "ASIA1111111111111111").Identity().Id(),
+			},
+		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCloudTrail(t, assumeRole("1", "ASIA1111111111111111"))
+
+			got, inScope := c.enrich(tt.event)
+			if !inScope {
+				t.Errorf("enrich() inScope = false, want true")
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreUnexported(EnrichedEvent{})); diff != "" {
+				t.Errorf("enrich() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// stdinSource returns events and then blocks forever, like an idle stdin does.
+type stdinSource struct {
+	events  []types.AssumeRoleEvent
+	blocked chan struct{}
+
+	// acked are the IDs of the events that were acknowledged.
+	acked []string
+}
+
+func (s *stdinSource) Next() (input.Record, error) {
+	if len(s.events) == 0 {
+		close(s.blocked)
+		select {}
+	}
+	event := s.events[0]
+	s.events = s.events[1:]
+
+	b, err := json.Marshal(event)
+	ack := func() error {
+		s.acked = append(s.acked, event.EventID)
+		return nil
+	}
+	return input.Record{Event: b, Ack: ack}, err
+}
+
+// Cancelling stops the CloudTrail without waiting for a blocked input, held events are still written out and the
+// sessions saved.
+func TestCloudTrail_Cancel(t *testing.T) {
+	ctx := utils.NewContext(context.Background(), false)
+	src := &stdinSource{
+		events:  []types.AssumeRoleEvent{assumeRole("1", "ASIA1111111111111111"), use("2", "ASIA1111111111111111")},
+		blocked: make(chan struct{}),
+	}
+	state := filepath.Join(t.TempDir(), "state.json")
+
+	c, err := NewCloudTrail(ctx, src, Args{Window: time.Hour, WindowSize: session.DefaultSize, Orphans: session.DefaultOrphans, State: state})
+	if err != nil {
+		t.Fatalf("NewCloudTrail() error = %v", err)
+	}
+
+	out := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(c)
+		out <- string(b)
+	}()
+
+	<-src.blocked
+	ctx.Cancel()
+
+	select {
+	case got := <-out:
+		if n := strings.Count(got, "\n"); n != 2 {
+			t.Errorf("wrote %d events, want 2:\n%s", n, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("CloudTrail didn't stop after being cancelled")
+	}
+	<-c.done
+
+	if _, err := os.Stat(state); err != nil {
+		t.Errorf("state wasn't saved: %v", err)
+	}
+}
+
+// runCloudTrail writes out events with args, returning "correction:<eventID>" for each correction that was written
+// and the eventID of everything else, along with the IDs of the events that were acknowledged.
+func runCloudTrail(t *testing.T, args Args, events ...types.AssumeRoleEvent) (written, acked []string) {
+	t.Helper()
+
+	ctx := utils.NewContext(context.Background(), false)
+	src := &stdinSource{events: events, blocked: make(chan struct{})}
+	args.WindowSize = session.DefaultSize
+	args.Orphans = session.DefaultOrphans
+
+	c, err := NewCloudTrail(ctx, src, args)
+	if err != nil {
+		t.Fatalf("NewCloudTrail() error = %v", err)
+	}
+	go func() {
+		<-src.blocked
+		ctx.Cancel()
+	}()
+
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	<-c.done
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var out struct {
+			EventID    string              `json:"eventID"`
+			Correction *session.Correction `json:"correction"`
+		}
+		if err := json.Unmarshal([]byte(line), &out); err != nil {
+			t.Fatalf("Unmarshal() error = %v: %s", err, line)
+		}
+		if out.Correction != nil {
+			written = append(written, "correction:"+out.Correction.EventID)
+		} else {
+			written = append(written, out.EventID)
+		}
+	}
+	return written, src.acked
+}
+
+// Events made with a session we haven't seen the creation of yet are still written with -follow-identity once it
+// turns out they were in scope. They aren't acknowledged until they've either been written or dropped.
+func TestCloudTrail_FollowIdentity_Orphans(t *testing.T) {
 	tests := []struct {
-		name     string
-		sessions map[string]types.UserIdentity
-		args args
-		want orig.EnrichedEvent
+		name        string
+		identity    string
+		events      []types.AssumeRoleEvent
+		wantWritten []string
+		wantAcked   []string
 	}{
 		{
-			name:     "asdf",
-			sessions: map[string]types.UserIdentity{},
-			args: args{event: types.AssumeRoleEvent{
-				EventName: "AssumeRole",
-				UserIdentity: types.UserIdentity{
-					Type:      "AWSService",
-					InvokedBy: "Lambda",
-				},
-				ResponseElements: types.ResponseElements{
-					Credentials: &types.Credentials{
-						AccessKeyId: "access-key",
-					},
-				},
-				EventTime: testDate,
-			}},
-			want: orig.EnrichedEvent{},
+			// Left out until its session shows up, then written in full.
+			name:        "user",
+			identity:    testUser.Arn,
+			events:      []types.AssumeRoleEvent{use("2", "ASIA1111111111111111"), assumeRole("1", "ASIA1111111111111111")},
+			wantWritten: []string{"2", "1"},
+			wantAcked:   []string{"2", "1"},
+		},
+		{
+			// Written straight away since the access key matches, the correction follows once its session shows up.
+			name:        "access key",
+			identity:    "ASIA1111111111111111",
+			events:      []types.AssumeRoleEvent{use("2", "ASIA1111111111111111"), assumeRole("1", "ASIA1111111111111111")},
+			wantWritten: []string{"2", "correction:2"},
+			wantAcked:   []string{"2", "1"},
+		},
+		{
+			// Dropped once its session shows it isn't in scope.
+			name:      "other user",
+			identity:  "arn:aws:iam::111111111111:user/other",
+			events:    []types.AssumeRoleEvent{use("2", "ASIA1111111111111111"), assumeRole("1", "ASIA1111111111111111")},
+			wantAcked: []string{"2", "1"},
+		},
+		{
+			// Still waiting for its session, so it's left to be read again.
+			name:     "never resolved",
+			identity: testUser.Arn,
+			events:   []types.AssumeRoleEvent{use("2", "ASIA1111111111111111")},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := orig.CloudTrail{
-				//Sessions: tt.fields.Sessions,
+			written, acked := runCloudTrail(t, Args{Identity: tt.identity}, tt.events...)
+			if diff := cmp.Diff(tt.wantWritten, written); diff != "" {
+				t.Errorf("run() written mismatch (-want +got):\n%s", diff)
 			}
-			if got := c.enrich(tt.args.event); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("enrich() = %v, want %v", got, tt.want)
+			if diff := cmp.Diff(tt.wantAcked, acked); diff != "" {
+				t.Errorf("run() acked mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
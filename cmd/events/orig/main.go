@@ -1,94 +1,184 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/ryanjarv/goutil/utils"
+	"github.com/ryanjarv/sqs/index"
+	"github.com/ryanjarv/sqs/input"
+	"github.com/ryanjarv/sqs/session"
 	"github.com/ryanjarv/sqs/types"
 	"io"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type Args struct {
-	Debug bool
+	Debug      bool
+	Window     time.Duration
+	WindowSize int
+	Orphans    int
+	Lineage    int
+	Grace      time.Duration
+	State      string
+	Checkpoint time.Duration
+	Index      string
+	Identity   string
+	input.Flags
+}
+
+// subcommands are run instead of tailing events when they're given as the first argument.
+var subcommands = map[string]func(ctx utils.Context, arguments []string) error{
+	"query": Query,
+	"whois": Whois,
 }
 
 func main() {
 	ctx := utils.NewContext(context.Background(), true)
 
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(ctx, os.Args[2:]); err != nil {
+				ctx.Error.Fatalln(err)
+			}
+			return
+		}
+	}
+
 	args := Args{}
 
 	flag.Usage = func() {
 		w := flag.CommandLine.Output() // may be os.Stderr - but not necessarily
 		_, _ = fmt.Fprintln(w, "Usage of ctnorm:")
 		flag.PrintDefaults()
-		_, _ = fmt.Fprintln(w, `TODO`)
+		_, _ = fmt.Fprintln(w, `
+Subcommands:
+  query    Search the sessions saved with -index, see ctnorm query -h
+  whois    Find who ultimately made a call, see ctnorm whois -h`)
 	}
 
 	flag.BoolVar(&args.Debug, "debug", false, "Enable debug output")
+	flag.DurationVar(&args.Window, "window", session.DefaultWindow, "How long to hold events so ones delivered out of order can be sorted on eventTime, 0 disables this")
+	flag.IntVar(&args.WindowSize, "window-size", session.DefaultSize, "Most events to hold at once for -window")
+	flag.IntVar(&args.Orphans, "orphans", session.DefaultOrphans, "Most events to remember while waiting for the event that created their session, a correction is written if it shows up")
+	flag.IntVar(&args.Lineage, "lineage", session.DefaultLineage, "Most sessions to include in the lineage of each event, 0 leaves it out")
+	flag.DurationVar(&args.Grace, "grace", session.DefaultGrace, "How long to remember sessions after they expire, in eventTime, 0 keeps them forever")
+	flag.StringVar(&args.State, "state", "", "File to save sessions to, they're restored from it on start up")
+	flag.DurationVar(&args.Checkpoint, "checkpoint", time.Minute, "How often to save sessions to -state, they're also saved on exit")
+	flag.StringVar(&args.Index, "index", "", "Database to save sessions to so they can be searched with the query subcommand")
+	flag.StringVar(&args.Identity, "follow-identity", "", "Only output events made by this ARN, access key ID or session key, or by sessions derived from it")
+	args.Flags.Register(flag.CommandLine)
 	flag.Parse()
 
 	if args.Debug {
 		ctx.SetLoggingLevel(utils.DebugLogLevel)
 	}
 
-	if len(flag.Args()) > 1 {
-		ctx.Error.Fatalln("extra arguments detected, did you mean to pass a comma seperated list to -profiles instead?")
-	}
+	// Stop cleanly on SIGTERM so sessions are saved to -state, a second signal exits straight away in case writing
+	// out what's left is stuck.
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		ctx.Cancel()
+		<-signals
+		os.Exit(1)
+	}()
 
-	err := Run(ctx, flag.Arg(0))
+	err := Run(ctx, args, flag.Args())
 	if err != nil {
 		ctx.Error.Fatalln(err)
 	}
 }
 
-func Run(ctx utils.Context, path string) error {
-	var in io.ReadCloser
-
-	if path == "" || path == "-" {
-		in = os.Stdin
-	} else {
-		var err error
-		in, err = os.Open(path)
-		if err != nil {
-			return fmt.Errorf("opening %s: %w", path, err)
+// Run reads events from each of paths, when more than one is given events are merged in eventTime order.
+func Run(ctx utils.Context, args Args, paths []string) error {
+	src, err := args.Open(ctx, paths)
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
+	if q, ok := src.(*input.Queue); ok {
+		q.OnError = func(err error) {
+			ctx.Info.Println("warning:", err)
 		}
 	}
-	ct := NewCloudTrail(ctx, in)
+	ct, err := NewCloudTrail(ctx, src, args)
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
 
-	var err error
+	// The output is closed by run once it has written out the held events, it doesn't wait for the input to stop.
 	go func() {
 		<-ctx.Done()
-		err := ct.Close()
-		if err != nil {
-			ctx.Error.Println("run: closing cloudtrail stream: %s")
+		if closer, ok := src.(io.Closer); ok {
+			_ = closer.Close()
 		}
 	}()
 
 	_, err = io.Copy(os.Stdout, ct)
-	if err != nil {
+
+	// Wait for the state to be saved.
+	<-ct.done
+
+	if err != nil && ctx.Err() == nil {
 		return fmt.Errorf("run: copy to stdout: %w", err)
 	}
 	return nil
 }
 
-func NewCloudTrail(ctx utils.Context, r io.ReadCloser) *CloudTrail {
+// NewCloudTrail starts enriching events from src, sessions are restored from args.State if it's set.
+func NewCloudTrail(ctx utils.Context, src input.Source, args Args) (*CloudTrail, error) {
+	tracker := session.NewTracker(args.Orphans)
+	if args.State != "" {
+		if err := loadState(args.State, tracker); err != nil {
+			return nil, err
+		}
+		ctx.Debug.Printf("cloudtrail: restored %d sessions from %s\n", tracker.Len(), args.State)
+	}
+
+	var idx *index.Index
+	if args.Index != "" {
+		var err error
+		idx, err = index.Open(args.Index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	pipe, writer := io.Pipe()
 
 	c := &CloudTrail{
-		PipeReader:    pipe,
-		in:            bufio.NewReader(r),
-		out:           writer,
-		ctx:           ctx,
-		Sessions:      &sync.Map{},
-		sessionTokens: map[string]bool{},
+		PipeReader: pipe,
+		in:         src,
+		out:        writer,
+		ctx:        ctx,
+		Tracker:    tracker,
+		reorder:    session.NewReorder(args.Window, args.WindowSize),
+		lineage:    args.Lineage,
+		grace:      args.Grace,
+		state:      args.State,
+		checkpoint: args.Checkpoint,
+		done:       make(chan struct{}),
+		index:      idx,
+	}
+	if args.Identity != "" {
+		c.scope = session.NewScope(args.Identity)
+		c.orphans = map[string]*parked{}
+		c.maxOrphans = args.Orphans
+	}
+	if idx != nil {
+		tracker.OnCreate = func(sess session.Session) {
+			c.batch.Sessions = append(c.batch.Sessions, sess)
+		}
 	}
 
 	go func() {
+		defer close(c.done)
 		err := c.run()
 		if err != nil {
 			ctx.Error.Println("cloudtrail: run:", err)
@@ -96,53 +186,318 @@ func NewCloudTrail(ctx utils.Context, r io.ReadCloser) *CloudTrail {
 		}
 	}()
 
-	return c
+	return c, nil
 }
 
 type CloudTrail struct {
 	*io.PipeReader
-	in            *bufio.Reader
-	out           *io.PipeWriter
-	ctx           utils.Context
-	Sessions      *sync.Map
-	sessionTokens map[string]bool
+	in      input.Source
+	out     *io.PipeWriter
+	ctx     utils.Context
+	Tracker *session.Tracker
+	reorder *session.Reorder
+
+	// lineage is the most hops to include in the lineage of each event.
+	lineage int
+
+	// grace is how long sessions are kept after they expire.
+	grace time.Duration
+
+	// state is the file sessions are saved to every checkpoint and when run returns.
+	state      string
+	checkpoint time.Duration
+
+	// done is closed once run has returned.
+	done chan struct{}
+
+	// index is where sessions and events are saved for the query and whois subcommands, batch is what's waiting to
+	// be added to it. Events that were written out are only acknowledged once they're in the index, unacked are the
+	// ones waiting.
+	index   *index.Index
+	batch   index.Batch
+	unacked []input.Record
+
+	// scope limits output to events by -follow-identity, it's nil if everything is written.
+	scope *session.Scope
+
+	// orphans are the events made with a session we didn't know about yet when they were written, while scope is
+	// set. Events that were left out because of it are parked so they can be written once their session shows up, the
+	// ones that were written are nil. At most maxOrphans are remembered.
+	orphans    map[string]*parked
+	orphanList []string
+	maxOrphans int
+}
+
+// parked is an event left out of the output until we know whether it's in scope. It's only acknowledged once it's
+// been written, or dropped because it turned out not to be or was forgotten.
+type parked struct {
+	event EnrichedEvent
+	rec   input.Record
 }
 
-func (c *CloudTrail) run() error {
+// indexBatch is how many new sessions and events are buffered before they're added to the index, they're otherwise
+// added once a second when the reorder buffer is checked for events to release.
+const indexBatch = 1000
+
+// decoded is an event read from the input, or the error that stopped the input.
+type decoded struct {
+	rec   input.Record
+	event types.AssumeRoleEvent
+	err   error
+}
+
+func (c *CloudTrail) run() (err error) {
 	defer c.out.Close()
+	if c.index != nil {
+		defer c.index.Close()
+	}
+	defer func() {
+		if ferr := c.flush(); err == nil {
+			err = ferr
+		}
+	}()
+	defer func() {
+		if serr := c.save(); err == nil {
+			err = serr
+		}
+	}()
+
+	events := make(chan decoded)
+	go c.read(events)
+
+	// Events are released from the reorder buffer after a while even if nothing new comes in, check for them
+	// periodically so a quiet -follow doesn't hold them forever.
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	evict := time.NewTicker(time.Minute)
+	defer evict.Stop()
+
+	var checkpoint <-chan time.Time
+	if c.state != "" && c.checkpoint > 0 {
+		t := time.NewTicker(c.checkpoint)
+		defer t.Stop()
+		checkpoint = t.C
+	}
+
+	for {
+		select {
+		case d, ok := <-events:
+			if !ok {
+				c.report()
+				return c.write(c.reorder.Flush())
+			} else if d.err != nil {
+				return d.err
+			}
+
+			// Sessions are recorded as soon as they're read, events made with them may already be in the buffer.
+			corrections, err := c.Tracker.Observe(d.event)
+			var collision session.CollisionError
+			if errors.As(err, &collision) {
+				// Both sessions are tracked, events made with them will have a lower confidence.
+				c.ctx.Info.Println("warning:", err)
+			} else if err != nil {
+				return fmt.Errorf("tracking sessions: %w", err)
+			}
+			for _, correction := range corrections {
+				if err := c.correct(correction); err != nil {
+					return err
+				}
+			}
 
-	scanner := bufio.NewScanner(c.in)
+			c.reorder.Push(d.event, d.rec)
+			if err := c.write(c.reorder.Ready()); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := c.write(c.reorder.Ready()); err != nil {
+				return err
+			}
+			if err := c.flush(); err != nil {
+				return err
+			}
+		case <-evict.C:
+			if c.grace > 0 {
+				n := c.Tracker.Evict(c.grace)
+				c.ctx.Debug.Printf("cloudtrail: evicted %d expired sessions, %d left\n", n, c.Tracker.Len())
+			}
+		case <-checkpoint:
+			if err := c.save(); err != nil {
+				return err
+			}
+		case <-c.ctx.Done():
+			// Reading may never return, stdin for example can't be interrupted, so stop without waiting for it.
+			c.report()
+			return c.write(c.reorder.Flush())
+		}
+	}
+}
+
+// save writes the sessions we know about to the state file, if there is one.
+func (c *CloudTrail) save() error {
+	if c.state == "" {
+		return nil
+	}
+	if err := saveState(c.state, c.Tracker); err != nil {
+		return err
+	}
+	c.ctx.Debug.Printf("cloudtrail: saved %d sessions to %s\n", c.Tracker.Len(), c.state)
+	return nil
+}
+
+// report logs the identities that were seen but not handled properly, so they can be looked into.
+func (c *CloudTrail) report() {
+	for shape, n := range c.Tracker.Unhandled() {
+		c.ctx.Info.Printf("warning: %d events with unhandled identities: %s\n", n, shape)
+	}
+}
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
+// read decodes events from the input and sends them to events, events is closed at the end of the input.
+func (c *CloudTrail) read(events chan<- decoded) {
+	defer close(events)
 
-		// Skip blank lines
-		if len(line) == 0 {
-			continue
+	for {
+		rec, err := c.in.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			err = fmt.Errorf("reading input: %w", err)
 		}
 
 		var event types.AssumeRoleEvent
-		err := json.Unmarshal(line, &event)
+		if err == nil {
+			err = json.Unmarshal(rec.Event, &event)
+			if err != nil {
+				c.ctx.Debug.Printf("cloudtrail: failed to unmarshal: %b", rec.Event)
+				err = fmt.Errorf("failed to unmarshal: %s", err)
+			}
+		}
 
+		select {
+		case events <- decoded{rec: rec, event: event, err: err}:
+		case <-c.ctx.Done():
+			return
+		}
 		if err != nil {
-			c.ctx.Debug.Printf("cloudtrail: failed to unmarshal: %b", line)
-			return fmt.Errorf("failed to unmarshal: %s", err)
+			return
 		}
+	}
+}
 
-		enriched := c.enrich(event)
-		b, err := json.Marshal(enriched)
-		if err != nil {
-			c.ctx.Debug.Printf("cloudtrail: failed to marshal: %+v", event)
-			return fmt.Errorf("failed to marshal: %w", err)
+// write enriches and writes out events released from the reorder buffer.
+func (c *CloudTrail) write(held []session.Held) error {
+	for _, h := range held {
+		rec := h.Value.(input.Record)
+
+		enriched, inScope := c.enrich(h.Event)
+		enriched.Envelope = rec.Envelope
+		if c.index != nil {
+			c.batch.Events = append(c.batch.Events, index.NewEvent(h.Event, enriched.session))
 		}
-		_, err = c.out.Write(append(b, '\n'))
-		if err != nil {
-			return fmt.Errorf("writing to output: %w", err)
+
+		if c.scope != nil && enriched.session == nil && session.Derived(h.Event.Identity()) {
+			if c.orphan(enriched, rec, inScope) {
+				continue
+			}
+		}
+		if inScope {
+			if err := c.encode(enriched); err != nil {
+				return err
+			}
+		}
+		c.unacked = append(c.unacked, rec)
+	}
+
+	if c.index == nil || len(c.batch.Sessions)+len(c.batch.Events) >= indexBatch {
+		return c.flush()
+	}
+	return nil
+}
+
+// flush adds the new sessions and events to the index in a single transaction, then acknowledges the events that were
+// written out before it.
+func (c *CloudTrail) flush() error {
+	if c.index != nil {
+		if err := c.index.Write(c.batch); err != nil {
+			return err
+		}
+		c.batch = index.Batch{}
+	}
+
+	for _, rec := range c.unacked {
+		if err := input.Ack(rec); err != nil {
+			return fmt.Errorf("acknowledging event: %w", err)
+		}
+	}
+	c.unacked = c.unacked[:0]
+	return nil
+}
+
+// orphan remembers an event released before its session was known, so it can be handled by correct once it is. It
+// returns true if the event was parked rather than written, rec is then acknowledged later.
+func (c *CloudTrail) orphan(event EnrichedEvent, rec input.Record, inScope bool) bool {
+	if event.EventID == "" {
+		return false
+	}
+
+	if inScope {
+		c.orphans[event.EventID] = nil
+	} else {
+		c.orphans[event.EventID] = &parked{event: event, rec: rec}
+	}
+	c.orphanList = append(c.orphanList, event.EventID)
+
+	for len(c.orphanList) > c.maxOrphans {
+		// Parked events that are forgotten are dropped.
+		if p := c.orphans[c.orphanList[0]]; p != nil {
+			c.unacked = append(c.unacked, p.rec)
+		}
+		delete(c.orphans, c.orphanList[0])
+		c.orphanList = c.orphanList[1:]
+	}
+	return !inScope
+}
+
+// correct writes out what we've learned about an event now that the session it was made with has shown up.
+func (c *CloudTrail) correct(correction session.Correction) error {
+	c.ctx.Debug.Printf("enrich: resolved orphan %s -> %s\n", correction.EventID, correction.SessionKey)
+	if c.index != nil {
+		c.batch.Corrections = append(c.batch.Corrections, correction)
+	}
+	if c.scope == nil {
+		return c.encode(Correction{Correction: correction})
+	}
+
+	left, ok := c.orphans[correction.EventID]
+	delete(c.orphans, correction.EventID)
+
+	switch {
+	case left != nil:
+		// We couldn't tell if it was in scope without its session, it's written in full if it turns out it was.
+		// Either way we're done with it.
+		c.unacked = append(c.unacked, left.rec)
+
+		enriched, inScope := c.enrich(left.event.AssumeRoleEvent)
+		if !inScope {
+			return nil
 		}
+		enriched.Envelope = left.event.Envelope
+		return c.encode(enriched)
+	case ok, c.scope.ContainsKey(correction.SessionKey):
+		return c.encode(Correction{Correction: correction})
 	}
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("reading standard input: %w", err)
+func (c *CloudTrail) encode(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		c.ctx.Debug.Printf("cloudtrail: failed to marshal: %+v", v)
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = c.out.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing to output: %w", err)
 	}
 	return nil
 }
@@ -150,47 +505,95 @@ func (c *CloudTrail) run() error {
 type EnrichedEvent struct {
 	types.AssumeRoleEvent
 	OriginalUserIdentity interface{}
+
+	// Confidence is how sure we are about OriginalUserIdentity.
+	Confidence session.Confidence `json:"confidence,omitempty"`
+
+	// MfaAuthenticated is whether the session the event was made with was created with MFA, it's left out for
+	// requests that don't have a session context, such as ones made with long-term access keys.
+	MfaAuthenticated *bool `json:"mfaAuthenticated,omitempty"`
+
+	// Federation is the SAML or OIDC identity the session was created for, if it was created by
+	// AssumeRoleWithSAML or AssumeRoleWithWebIdentity.
+	Federation *types.Federation `json:"federation,omitempty"`
+
+	// Workload is the EC2 instance, Lambda function, ECS task or CodeBuild build that made the call, if it was made
+	// with a session an AWS service created for one.
+	Workload *types.Workload `json:"workload,omitempty"`
+
+	// Lineage is the chain of sessions the event was made with, starting with the session the event was made with and
+	// ending with the one created by a long-term identity. LineageTruncated is set if it was cut short by -lineage.
+	Lineage          []session.Hop `json:"lineage,omitempty"`
+	LineageTruncated bool          `json:"lineageTruncated,omitempty"`
+
+	// Expired is set when the event was made after its session expired, which shouldn't be possible.
+	Expired bool `json:"expired,omitempty"`
+
+	// RootActivity is set for events made by the root user, or with a session created by the root user.
+	RootActivity bool `json:"rootActivity,omitempty"`
+
+	// SessionKey is the key of the session the event was made with, if it's known.
+	SessionKey string `json:"sessionKey,omitempty"`
+
+	// Candidates are the sessions this event could have been made with when more than one had the same key.
+	Candidates []session.Session `json:"candidates,omitempty"`
+
+	// Envelope is the EventBridge metadata for events that were delivered through EventBridge.
+	Envelope *input.Envelope `json:"envelope,omitempty"`
+
+	// session is the session the event was made with, if it's known.
+	session *session.Session
+}
+
+// Correction is written when the session of an event that was already written is found, it fills in the
+// OriginalUserIdentity that was missing from the earlier output.
+type Correction struct {
+	Correction session.Correction `json:"correction"`
 }
 
-func (c *CloudTrail) enrich(event types.AssumeRoleEvent) EnrichedEvent {
-	userId := event.UserIdentity.Id()
+// enrich adds what we know about the session event was made with, inScope is false if it should be left out because
+// of -follow-identity.
+func (c *CloudTrail) enrich(event types.AssumeRoleEvent) (resp EnrichedEvent, inScope bool) {
+	userId := event.Identity().Id()
 	c.ctx.Debug.Printf("enrich: event id: %s\n", userId)
 
-	resp := EnrichedEvent{AssumeRoleEvent: event}
-	value, loaded := c.Sessions.Load(userId)
-	if loaded {
-		resp.OriginalUserIdentity = value.(types.UserIdentity)
+	resp = EnrichedEvent{AssumeRoleEvent: event}
+	resp.RootActivity = event.UserIdentity.Type == "Root"
+	if mfa, ok := event.UserIdentity.MfaAuthenticated(); ok {
+		resp.MfaAuthenticated = &mfa
+	}
+	var hops []session.Hop
+	if a, ok := c.Tracker.Resolve(event); ok {
+		resp.session = &a.Session
+		resp.SessionKey = a.Session.Key
+		resp.OriginalUserIdentity = a.Session.Original
+		resp.Confidence = a.Confidence
+		resp.Expired = a.Expired
+		resp.Candidates = a.Candidates
+		resp.Federation = a.Session.Federation
+		resp.Workload = a.Session.Workload
+		resp.RootActivity = resp.RootActivity || c.Tracker.Root(a.Session)
+		if c.lineage > 0 {
+			resp.Lineage, resp.LineageTruncated = c.Tracker.Lineage(a.Session, c.lineage)
+			hops = resp.Lineage
+		}
+		if c.scope != nil && c.lineage < session.DefaultLineage {
+			// The scope needs the full lineage even if less is written out.
+			hops, _ = c.Tracker.Lineage(a.Session, session.DefaultLineage)
+		}
+	}
+	if resp.Workload == nil {
+		resp.Workload = event.CallerWorkload()
 	}
 
 	//if event.ErrorCode != "" || !utils.In([]string{"AWSService", "AssumedRole"}, event.UserIdentity.Type) {
 	//	return EnrichedEvent{AssumeRoleEvent: event}
 	//}
 
-	// TODO: check other event types
-	if event.EventType == "AwsApiCall" {
-		if t := event.Target(); t != nil {
-			c.ctx.Debug.Printf("enrich: new identity: %s -> %s\n", userId, t.Id())
-			_, loaded := c.Sessions.LoadOrStore(t.Id(), event.UserIdentity)
-
-			if loaded {
-				// If we've already seen this session token it's likely just a duplicate record.
-				if _, ok := c.sessionTokens[event.ResponseElements.Credentials.SessionToken]; ok {
-					c.ctx.Info.Println("duplicate session token found:", t.Id())
-				} else {
-					// In the case that we haven't, it's possible that a collision occurred.
-					//
-					// It is possible for two sessions to end up with the same set of identifiable userIdentity records.
-					// This *should* be rare, but it is worth investigating if it happens.
-					//
-					// More likely than not it's just a bug though.
-					c.ctx.Error.Fatalln("target id collision:", t.Id())
-				}
-			}
-			c.sessionTokens[event.ResponseElements.Credentials.SessionToken] = true
-		}
+	if c.scope != nil {
+		return resp, c.scope.Contains(event.Identity(), hops)
 	}
-
-	return resp
+	return resp, true
 }
 
 //func (c *CloudTrail) Close() error {
@@ -1,4 +1,53 @@
-package orig
+package main
 
-type State struct {
+import (
+	"errors"
+	"fmt"
+	"github.com/ryanjarv/sqs/session"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// loadState restores tracker from the state file at path, there's nothing to restore if it doesn't exist yet.
+func loadState(path string, tracker *session.Tracker) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	defer f.Close()
+
+	if err := tracker.Restore(f); err != nil {
+		return fmt.Errorf("load state %s: %w", path, err)
+	}
+	return nil
+}
+
+// saveState writes the state of tracker to path. It's written to a temporary file first and renamed over path, so
+// the previous checkpoint is kept if we're killed part way through.
+func saveState(path string, tracker *session.Tracker) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := tracker.Snapshot(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("save state: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("save state: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/ryanjarv/goutil/utils"
+	"github.com/ryanjarv/sqs/index"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Whois runs the whois subcommand, it prints the path from an event or access key back to whoever ultimately made
+// the call.
+func Whois(ctx utils.Context, arguments []string) error {
+	fs := flag.NewFlagSet("whois", flag.ExitOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "Usage of ctnorm whois: ctnorm whois -index <file> <event-id|access-key-id>")
+		fs.PrintDefaults()
+	}
+
+	var (
+		path   string
+		asJSON bool
+	)
+	fs.StringVar(&path, "index", "", "Database written by the -index argument")
+	fs.BoolVar(&asJSON, "json", false, "Print each hop as a JSON line instead of a table")
+	_ = fs.Parse(arguments)
+
+	if path == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("whois: -index and an event or access key ID are required")
+	}
+
+	idx, err := index.OpenReadOnly(path)
+	if err != nil {
+		return fmt.Errorf("whois: %w", err)
+	}
+	defer idx.Close()
+
+	paths, err := idx.Whois(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ctx.Debug.Printf("whois: found %d paths\n", len(paths))
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, path := range paths {
+			if err := enc.Encode(path); err != nil {
+				return fmt.Errorf("whois: %w", err)
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for n, path := range paths {
+		if n > 0 {
+			_, _ = fmt.Fprintln(w)
+		}
+		_, _ = fmt.Fprintln(w, "EVENT TIME\tEVENT NAME\tEVENT ID\tIDENTITY\tSOURCE IP\tUSER AGENT")
+		for _, hop := range path {
+			identity := hop.Identity.Arn
+			if identity == "" {
+				identity = hop.Identity.Id()
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", hop.EventTime.Format(time.RFC3339), hop.EventName, hop.EventID, identity, hop.SourceIPAddress, hop.UserAgent)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,210 @@
+// Package index stores the sessions seen by the session tracker on disk, so they can be searched long after the
+// events that created them were tailed.
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ryanjarv/sqs/session"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"time"
+)
+
+// Buckets, sessions holds the sessions themselves and the rest map a value to the IDs of the sessions with it. Index
+// keys are the value followed by sep and the session ID, so all sessions with a value can be found with a prefix
+// scan.
+var (
+	sessionsBucket  = []byte("sessions")
+	keyBucket       = []byte("sessionKey")
+	accessKeyBucket = []byte("accessKey")
+	principalBucket = []byte("principal")
+	sourceIPBucket  = []byte("sourceIP")
+	parentBucket    = []byte("parent")
+	timeBucket      = []byte("time")
+)
+
+var buckets = [][]byte{sessionsBucket, keyBucket, accessKeyBucket, principalBucket, sourceIPBucket, parentBucket, timeBucket, eventsBucket, eventAccessKeyBucket}
+
+const sep = 0
+
+// Index is an on-disk index of sessions.
+type Index struct {
+	db *bolt.DB
+}
+
+// Open opens the index at path for writing, creating it if it doesn't exist. Only one process can have an index open
+// for writing, it's locked until Close is called.
+func Open(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+	return &Index{db: db}, nil
+}
+
+// OpenReadOnly opens the existing index at path for searching.
+//
+// Readers can share an index, but not with a writer. It can't be opened while ctnorm is still running with -index,
+// a live tail keeps the index locked for as long as it runs.
+func OpenReadOnly(path string) (*Index, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("open index: %s is locked, it can't be read while ctnorm is writing to it", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+	return &Index{db: db}, nil
+}
+
+func (i *Index) Close() error {
+	return i.db.Close()
+}
+
+// id returns the ID sess is stored under. Both account's copies of a cross-account call are stored under their
+// sharedEventID, so the source account's copy replaces the target account's when it's seen.
+//
+// Events don't always have an ID, CloudTrail Lake results only have the columns that were selected, those are stored
+// under the session key and the time they were created instead.
+func id(sess session.Session) []byte {
+	if sess.SharedEventID != "" {
+		return []byte(sess.SharedEventID)
+	} else if sess.EventID != "" {
+		return []byte(sess.EventID)
+	}
+	return []byte(sess.Key + "@" + sess.EventTime.Format(time.RFC3339Nano))
+}
+
+// Batch is a set of changes written to the index in a single transaction.
+type Batch struct {
+	Sessions []session.Session
+	Events   []Event
+
+	// Corrections are applied last, so they can refer to sessions and events in the same batch.
+	Corrections []session.Correction
+}
+
+// Write stores everything in b, each transaction is synced to disk so it's best to write in batches.
+func (i *Index) Write(b Batch) error {
+	if len(b.Sessions) == 0 && len(b.Events) == 0 && len(b.Corrections) == 0 {
+		return nil
+	}
+
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		for _, sess := range b.Sessions {
+			if err := addSession(tx, sess); err != nil {
+				return err
+			}
+		}
+		for _, e := range b.Events {
+			if err := addEvent(tx, e); err != nil {
+				return err
+			}
+		}
+		for _, c := range b.Corrections {
+			if err := correct(tx, c.EventID, c.SessionKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("write to index: %w", err)
+	}
+	return nil
+}
+
+// Add stores sessions in the index, replacing any that were already stored under the same ID.
+func (i *Index) Add(sessions ...session.Session) error {
+	return i.Write(Batch{Sessions: sessions})
+}
+
+func addSession(tx *bolt.Tx, sess session.Session) error {
+	id := id(sess)
+
+	b, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(sessionsBucket).Put(id, b); err != nil {
+		return err
+	}
+
+	for bucket, value := range map[string]string{
+		string(keyBucket):       sess.Key,
+		string(accessKeyBucket): sess.Target.AccessKeyId,
+		string(sourceIPBucket):  sess.SourceIPAddress,
+		string(parentBucket):    sess.Parent,
+	} {
+		if err := put(tx.Bucket([]byte(bucket)), []byte(value), id); err != nil {
+			return err
+		}
+	}
+
+	// Sessions are found by both the ARN of the session and the ARN of whoever created it.
+	for _, arn := range []string{sess.Target.Arn, sess.Original.Arn} {
+		if err := put(tx.Bucket(principalBucket), []byte(arn), id); err != nil {
+			return err
+		}
+	}
+
+	return put(tx.Bucket(timeBucket), timeKey(sess.EventTime), id)
+}
+
+// put adds id to the index bucket b under value, empty values aren't indexed.
+func put(b *bolt.Bucket, value, id []byte) error {
+	if len(value) == 0 {
+		return nil
+	}
+	return b.Put(indexKey(value, id), nil)
+}
+
+func indexKey(value, id []byte) []byte {
+	k := make([]byte, 0, len(value)+1+len(id))
+	k = append(k, value...)
+	k = append(k, sep)
+	return append(k, id...)
+}
+
+// timeKey sorts in time order.
+func timeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+// ids returns the IDs of the sessions indexed under value in b.
+func ids(b *bolt.Bucket, value []byte) (ids [][]byte) {
+	// Indexes added since the database was created are missing until it's next opened for writing.
+	if b == nil {
+		return nil
+	}
+
+	prefix := append(append([]byte{}, value...), sep)
+
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		ids = append(ids, append([]byte{}, k[len(prefix):]...))
+	}
+	return ids
+}
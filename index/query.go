@@ -0,0 +1,182 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/sqs/session"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+// Query selects sessions from the index, sessions have to match every field that's set. An empty query matches
+// every session.
+type Query struct {
+	SessionKey string
+	AccessKey  string
+
+	// Principal matches sessions for or created by the given ARN.
+	Principal string
+
+	// SourceIP matches the sourceIPAddress of the event that created the session.
+	SourceIP string
+
+	// Start and End limit the query to sessions created in this range, either can be left zero.
+	Start time.Time
+	End   time.Time
+
+	// Descendants includes every session created with the matching sessions, directly or through other sessions.
+	Descendants bool
+}
+
+// Find returns the sessions matching q ordered by when they were created.
+func (i *Index) Find(q Query) (found []session.Session, err error) {
+	err = i.db.View(func(tx *bolt.Tx) error {
+		ids, err := q.ids(tx)
+		if err != nil {
+			return err
+		}
+
+		seen := map[string]bool{}
+		for _, id := range ids {
+			sess, ok, err := get(tx, id)
+			if err != nil {
+				return err
+			} else if !ok || !q.match(sess) || seen[string(id)] {
+				continue
+			}
+			seen[string(id)] = true
+			found = append(found, sess)
+		}
+
+		if q.Descendants {
+			found, err = descendants(tx, found, seen)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query index: %w", err)
+	}
+
+	sort.SliceStable(found, func(a, b int) bool {
+		return found[a].EventTime.Before(found[b].EventTime)
+	})
+	return found, nil
+}
+
+// ids returns the IDs of the sessions that could match q using the indexes for the fields that are set, the sessions
+// still need to be checked with match.
+func (q Query) ids(tx *bolt.Tx) ([][]byte, error) {
+	var sets [][][]byte
+	for bucket, value := range map[string]string{
+		string(keyBucket):       q.SessionKey,
+		string(accessKeyBucket): q.AccessKey,
+		string(principalBucket): q.Principal,
+		string(sourceIPBucket):  q.SourceIP,
+	} {
+		if value != "" {
+			sets = append(sets, ids(tx.Bucket([]byte(bucket)), []byte(value)))
+		}
+	}
+
+	switch {
+	case len(sets) > 0:
+		return intersect(sets), nil
+	case !q.Start.IsZero() || !q.End.IsZero():
+		return timeRange(tx.Bucket(timeBucket), q.Start, q.End), nil
+	}
+
+	var all [][]byte
+	err := tx.Bucket(sessionsBucket).ForEach(func(k, _ []byte) error {
+		all = append(all, append([]byte{}, k...))
+		return nil
+	})
+	return all, err
+}
+
+// match checks the fields of q against sess, the indexes can have stale entries for sessions that were replaced.
+func (q Query) match(sess session.Session) bool {
+	switch {
+	case q.SessionKey != "" && sess.Key != q.SessionKey:
+		return false
+	case q.AccessKey != "" && sess.Target.AccessKeyId != q.AccessKey:
+		return false
+	case q.Principal != "" && sess.Target.Arn != q.Principal && sess.Original.Arn != q.Principal:
+		return false
+	case q.SourceIP != "" && sess.SourceIPAddress != q.SourceIP:
+		return false
+	case !q.Start.IsZero() && sess.EventTime.Before(q.Start):
+		return false
+	case !q.End.IsZero() && sess.EventTime.After(q.End):
+		return false
+	}
+	return true
+}
+
+// descendants adds the sessions created with each of sessions to it, seen are the IDs already included.
+func descendants(tx *bolt.Tx, sessions []session.Session, seen map[string]bool) ([]session.Session, error) {
+	for n := 0; n < len(sessions); n++ {
+		parent := sessions[n]
+		for _, id := range ids(tx.Bucket(parentBucket), []byte(parent.Key)) {
+			if seen[string(id)] {
+				continue
+			}
+
+			child, ok, err := get(tx, id)
+			if err != nil {
+				return nil, err
+			} else if !ok || child.Parent != parent.Key {
+				continue
+			}
+			seen[string(id)] = true
+			sessions = append(sessions, child)
+		}
+	}
+	return sessions, nil
+}
+
+// get returns the session stored under id.
+func get(tx *bolt.Tx, id []byte) (sess session.Session, ok bool, err error) {
+	b := tx.Bucket(sessionsBucket).Get(id)
+	if b == nil {
+		return sess, false, nil
+	}
+	if err := json.Unmarshal(b, &sess); err != nil {
+		return sess, false, fmt.Errorf("session %s: %w", id, err)
+	}
+	return sess, true, nil
+}
+
+// timeRange returns the IDs of the sessions created between start and end, either can be zero.
+func timeRange(b *bolt.Bucket, start, end time.Time) (ids [][]byte) {
+	c := b.Cursor()
+
+	k, _ := c.First()
+	if !start.IsZero() {
+		k, _ = c.Seek(timeKey(start))
+	}
+	for ; k != nil; k, _ = c.Next() {
+		if !end.IsZero() && bytes.Compare(k[:8], timeKey(end)) > 0 {
+			break
+		}
+		ids = append(ids, append([]byte{}, k[9:]...))
+	}
+	return ids
+}
+
+// intersect returns the IDs that are in all of sets.
+func intersect(sets [][][]byte) (ids [][]byte) {
+	counts := map[string]int{}
+	for _, set := range sets {
+		for _, id := range set {
+			counts[string(id)]++
+		}
+	}
+	for _, id := range sets[0] {
+		if counts[string(id)] == len(sets) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,283 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ryanjarv/sqs/session"
+	"github.com/ryanjarv/sqs/types"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+// Buckets, events holds the events themselves and eventAccessKey maps each access key to the most recent event made
+// with it.
+var (
+	eventsBucket         = []byte("events")
+	eventAccessKeyBucket = []byte("eventAccessKey")
+)
+
+// ErrNotFound is returned by Whois when there's no event or session for the ID it was given.
+var ErrNotFound = errors.New("not found")
+
+// Event is an event made with a session, events are stored so Whois can start from them.
+type Event struct {
+	EventID   string             `json:"eventID"`
+	EventName string             `json:"eventName"`
+	EventTime time.Time          `json:"eventTime"`
+	Identity  types.UserIdentity `json:"identity"`
+
+	SourceIPAddress string `json:"sourceIPAddress,omitempty"`
+	UserAgent       string `json:"userAgent,omitempty"`
+
+	// SessionKey and SessionID are the session the event was made with, they're empty if it wasn't known.
+	SessionKey string `json:"sessionKey,omitempty"`
+	SessionID  string `json:"sessionID,omitempty"`
+}
+
+// NewEvent returns the Event to store for event, sess is the session it was made with or nil if it isn't known.
+func NewEvent(event types.AssumeRoleEvent, sess *session.Session) Event {
+	e := Event{
+		EventID:         event.EventID,
+		EventName:       event.EventName,
+		EventTime:       event.EventTime,
+		Identity:        event.UserIdentity,
+		SourceIPAddress: event.SourceIPAddress,
+		UserAgent:       event.UserAgent,
+	}
+	if sess != nil {
+		e.SessionKey = sess.Key
+		e.SessionID = string(id(*sess))
+	}
+	return e
+}
+
+// AddEvents stores events in the index, events without an ID are skipped since they can't be looked up.
+func (i *Index) AddEvents(events ...Event) error {
+	return i.Write(Batch{Events: events})
+}
+
+func addEvent(tx *bolt.Tx, e Event) error {
+	if e.EventID == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(eventsBucket).Put([]byte(e.EventID), b); err != nil {
+		return err
+	}
+
+	// Only the most recent event is kept for each access key, it's enough for Whois to start from when the session
+	// the key belongs to isn't known.
+	if e.Identity.AccessKeyId == "" {
+		return nil
+	}
+	keys := tx.Bucket(eventAccessKeyBucket)
+	if latest, ok, err := event(tx, keys.Get([]byte(e.Identity.AccessKeyId))); err != nil {
+		return err
+	} else if ok && latest.EventTime.After(e.EventTime) {
+		return nil
+	}
+	return keys.Put([]byte(e.Identity.AccessKeyId), []byte(e.EventID))
+}
+
+// Correct updates the stored event with eventID once the session it was made with, key, shows up. Nothing is done
+// if the event isn't stored.
+func (i *Index) Correct(eventID, key string) error {
+	return i.Write(Batch{Corrections: []session.Correction{{EventID: eventID, SessionKey: key}}})
+}
+
+func correct(tx *bolt.Tx, eventID, key string) error {
+	e, ok, err := event(tx, []byte(eventID))
+	if err != nil || !ok {
+		return err
+	}
+
+	sess, ok, err := sessionAt(tx, key, e.EventTime)
+	if err != nil || !ok {
+		return err
+	}
+	e.SessionKey, e.SessionID = sess.Key, string(id(sess))
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(eventsBucket).Put([]byte(eventID), data)
+}
+
+// event returns the stored event with id, ok is false if there isn't one.
+func event(tx *bolt.Tx, id []byte) (e Event, ok bool, err error) {
+	b := tx.Bucket(eventsBucket)
+	if b == nil || len(id) == 0 {
+		return e, false, nil
+	}
+
+	data := b.Get(id)
+	if data == nil {
+		return e, false, nil
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, false, fmt.Errorf("event %s: %w", id, err)
+	}
+	return e, true, nil
+}
+
+// WhoisHop is one call in the path Whois returns.
+type WhoisHop struct {
+	EventID   string    `json:"eventID"`
+	EventName string    `json:"eventName"`
+	EventTime time.Time `json:"eventTime"`
+
+	// Identity made the call, from SourceIPAddress with UserAgent.
+	Identity        types.UserIdentity `json:"identity"`
+	SourceIPAddress string             `json:"sourceIPAddress,omitempty"`
+	UserAgent       string             `json:"userAgent,omitempty"`
+
+	// Session is the key of the session the call was made with, it's empty if it was made with a long-term identity
+	// or the session isn't known. Created is the key of the session the call created, if any.
+	Session string `json:"session,omitempty"`
+	Created string `json:"created,omitempty"`
+}
+
+// Whois walks backwards from an event ID or access key ID to whoever ultimately made the call. Each path starts with
+// the event, or for an access key the call that created it, followed by the call that created the session it was
+// made with and so on. The last hop was made by the originating principal.
+//
+// An access key can be used by more than one session if it was recycled, so a path is returned for each, most
+// recent first. Long-term access keys, and session keys whose creating call was never seen, start from the most
+// recent event made with the key instead. ErrNotFound is returned if neither an event or access key with id is in
+// the index.
+func (i *Index) Whois(id string) (paths [][]WhoisHop, err error) {
+	err = i.db.View(func(tx *bolt.Tx) error {
+		if path, ok, err := eventPath(tx, []byte(id)); err != nil || ok {
+			paths = append(paths, path)
+			return err
+		}
+
+		var sessions []session.Session
+		for _, id := range ids(tx.Bucket(accessKeyBucket), []byte(id)) {
+			sess, ok, err := get(tx, id)
+			if err != nil {
+				return err
+			} else if ok {
+				sessions = append(sessions, sess)
+			}
+		}
+		sort.SliceStable(sessions, func(a, b int) bool {
+			return sessions[a].EventTime.After(sessions[b].EventTime)
+		})
+
+		for _, sess := range sessions {
+			path, err := walk(tx, nil, sess)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+		}
+		if len(sessions) > 0 {
+			return nil
+		}
+
+		if keys := tx.Bucket(eventAccessKeyBucket); keys != nil {
+			if path, ok, err := eventPath(tx, keys.Get([]byte(id))); err != nil || ok {
+				paths = append(paths, path)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("whois: %w", err)
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("whois %s: %w", id, ErrNotFound)
+	}
+	return paths, nil
+}
+
+// eventPath returns the path starting from the stored event with id, ok is false if there isn't one. The event's own
+// identity is the last hop if the session it was made with isn't known.
+func eventPath(tx *bolt.Tx, id []byte) (path []WhoisHop, ok bool, err error) {
+	e, ok, err := event(tx, id)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	path = []WhoisHop{{
+		EventID:         e.EventID,
+		EventName:       e.EventName,
+		EventTime:       e.EventTime,
+		Identity:        e.Identity,
+		SourceIPAddress: e.SourceIPAddress,
+		UserAgent:       e.UserAgent,
+		Session:         e.SessionKey,
+	}}
+
+	sess, found, err := get(tx, []byte(e.SessionID))
+	if err != nil {
+		return nil, false, err
+	} else if found {
+		path, err = walk(tx, path, sess)
+	}
+	return path, true, err
+}
+
+// walk adds the call that created sess to path, followed by the calls that created each of its ancestors. It stops
+// after session.DefaultLineage hops in case the sessions loop.
+func walk(tx *bolt.Tx, path []WhoisHop, sess session.Session) ([]WhoisHop, error) {
+	seen := map[string]bool{}
+	for len(path) < session.DefaultLineage && !seen[sess.Key] {
+		seen[sess.Key] = true
+
+		path = append(path, WhoisHop{
+			EventID:         sess.EventID,
+			EventName:       sess.EventName,
+			EventTime:       sess.EventTime,
+			Identity:        sess.Original,
+			SourceIPAddress: sess.SourceIPAddress,
+			UserAgent:       sess.UserAgent,
+			Session:         sess.Parent,
+			Created:         sess.Key,
+		})
+
+		if sess.Parent == "" {
+			break
+		}
+		parent, ok, err := sessionAt(tx, sess.Parent, sess.EventTime)
+		if err != nil || !ok {
+			return path, err
+		}
+		sess = parent
+	}
+	return path, nil
+}
+
+// sessionAt returns the session with key that was in use at t. If more than one session has the key the most recent
+// one created before t is used, or failing that the most recent one.
+func sessionAt(tx *bolt.Tx, key string, t time.Time) (p session.Session, ok bool, err error) {
+	var before bool
+	for _, id := range ids(tx.Bucket(keyBucket), []byte(key)) {
+		candidate, found, err := get(tx, id)
+		if err != nil {
+			return p, false, err
+		} else if !found || candidate.Key != key {
+			continue
+		}
+
+		candidateBefore := !candidate.EventTime.After(t)
+		switch {
+		case !ok, candidateBefore && !before:
+		case candidateBefore == before && candidate.EventTime.After(p.EventTime):
+		default:
+			continue
+		}
+		p, ok, before = candidate, true, candidateBefore
+	}
+	return p, ok, nil
+}
@@ -0,0 +1,109 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// CloudWatchLogs reads CloudTrail events out of CloudWatch Logs subscription filter payloads.
+//
+// Two forms are supported, the payload itself which is what ends up in Kinesis or Firehose (the gzip compression is
+// handled by NewReader), and the {"awslogs": {"data": "..."}} wrapper Lambda receives where the payload is gzipped
+// and base64 encoded. In either case there may be any number of them one after another.
+type CloudWatchLogs struct {
+	dec *json.Decoder
+
+	// pending holds the events from the last payload that haven't been returned yet.
+	pending []json.RawMessage
+}
+
+// cloudWatchPayload is a subscription filter payload, or the Lambda wrapper around one.
+type cloudWatchPayload struct {
+	// MessageType is DATA_MESSAGE for log events, CONTROL_MESSAGE is sent to check the destination is reachable.
+	MessageType string `json:"messageType"`
+	LogGroup    string `json:"logGroup"`
+	LogStream   string `json:"logStream"`
+	LogEvents   []struct {
+		Id        string `json:"id"`
+		Timestamp int64  `json:"timestamp"`
+		Message   string `json:"message"`
+	} `json:"logEvents"`
+
+	AWSLogs *struct {
+		Data string `json:"data"`
+	} `json:"awslogs"`
+}
+
+func NewCloudWatchLogs(r io.Reader) *CloudWatchLogs {
+	return &CloudWatchLogs{dec: json.NewDecoder(r)}
+}
+
+func (c *CloudWatchLogs) Next() (Record, error) {
+	for len(c.pending) == 0 {
+		var payload cloudWatchPayload
+		if err := c.dec.Decode(&payload); err == io.EOF {
+			return Record{}, io.EOF
+		} else if err != nil {
+			return Record{}, fmt.Errorf("cloudwatch: decode: %w", err)
+		}
+
+		if err := c.read(payload); err != nil {
+			return Record{}, err
+		}
+	}
+
+	event := c.pending[0]
+	c.pending = c.pending[1:]
+	return Record{Event: event}, nil
+}
+
+// read adds the events in payload to c.pending.
+func (c *CloudWatchLogs) read(payload cloudWatchPayload) error {
+	if payload.AWSLogs != nil {
+		return c.readData(payload.AWSLogs.Data)
+	}
+
+	if payload.MessageType != "DATA_MESSAGE" {
+		return nil
+	}
+
+	for _, e := range payload.LogEvents {
+		event := json.RawMessage(e.Message)
+		if !json.Valid(event) {
+			return fmt.Errorf("cloudwatch: %s/%s: log event %s isn't a CloudTrail event", payload.LogGroup, payload.LogStream, e.Id)
+		}
+		c.pending = append(c.pending, event)
+	}
+	return nil
+}
+
+// readData decodes the base64 encoded and gzipped payloads from the Lambda wrapper.
+func (c *CloudWatchLogs) readData(data string) error {
+	compressed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("cloudwatch: decoding awslogs.data: %w", err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return fmt.Errorf("cloudwatch: decompressing awslogs.data: %w", err)
+	}
+
+	dec := json.NewDecoder(gz)
+	for {
+		var payload cloudWatchPayload
+		if err := dec.Decode(&payload); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("cloudwatch: decode awslogs.data: %w", err)
+		}
+
+		if err := c.read(payload); err != nil {
+			return err
+		}
+	}
+}
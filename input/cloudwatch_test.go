@@ -0,0 +1,86 @@
+package input
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func cloudWatchPayloadJSON(t *testing.T, messageType string, ids ...string) string {
+	var logEvents []map[string]interface{}
+	for i, id := range ids {
+		logEvents = append(logEvents, map[string]interface{}{
+			"id":        fmt.Sprintf("%d", i),
+			"timestamp": 1664582401000,
+			"message":   event(id, i),
+		})
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"messageType":         messageType,
+		"owner":               "111111111111",
+		"logGroup":            "aws-cloudtrail-logs",
+		"logStream":           "111111111111_CloudTrail_us-east-1",
+		"subscriptionFilters": []string{"ctail"},
+		"logEvents":           logEvents,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNewReader_CloudWatchLogs(t *testing.T) {
+	tests := []struct {
+		name string
+		data func(t *testing.T) string
+		want []string
+	}{
+		{
+			name: "payload",
+			data: func(t *testing.T) string {
+				return cloudWatchPayloadJSON(t, "DATA_MESSAGE", "1", "2")
+			},
+			want: []string{"1", "2"},
+		},
+		{
+			// Firehose concatenates the gzipped records it's sent.
+			name: "firehose",
+			data: func(t *testing.T) string {
+				return gz(t, cloudWatchPayloadJSON(t, "CONTROL_MESSAGE")) +
+					gz(t, cloudWatchPayloadJSON(t, "DATA_MESSAGE", "1")) +
+					gz(t, cloudWatchPayloadJSON(t, "DATA_MESSAGE", "2", "3"))
+			},
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name: "lambda",
+			data: func(t *testing.T) string {
+				wrap := func(payload string) string {
+					data := base64.StdEncoding.EncodeToString([]byte(gz(t, payload)))
+					return fmt.Sprintf(`{"awslogs": {"data": "%s"}}`, data)
+				}
+				return wrap(cloudWatchPayloadJSON(t, "DATA_MESSAGE", "1", "2")) + "\n" +
+					wrap(cloudWatchPayloadJSON(t, "DATA_MESSAGE", "3")) + "\n"
+			},
+			want: []string{"1", "2", "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewReader(bytes.NewBufferString(tt.data(t)))
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			if _, ok := src.(*CloudWatchLogs); !ok {
+				t.Fatalf("NewReader() = %T, want *CloudWatchLogs", src)
+			}
+			if diff := cmp.Diff(tt.want, eventIds(t, src)); diff != "" {
+				t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/sqs/schema/aws/logs/awsapicallviacloudtrail"
+	"io"
+	"strings"
+	"time"
+)
+
+// Envelope is the metadata from the EventBridge event a CloudTrail event was delivered in.
+type Envelope struct {
+	Id         string    `json:"id"`
+	DetailType string    `json:"detail-type"`
+	Source     string    `json:"source"`
+	Account    string    `json:"account"`
+	Region     string    `json:"region"`
+	Time       time.Time `json:"time"`
+	Resources  []string  `json:"resources,omitempty"`
+}
+
+// eventBridgeEvent is an EventBridge event with the CloudTrail record left as is.
+//
+// Detail shadows the typed field in AWSEvent, the generated type only covers a handful of the request parameters
+// and drops everything else.
+type eventBridgeEvent struct {
+	awsapicallviacloudtrail.AWSEvent
+	Detail json.RawMessage `json:"detail"`
+}
+
+var detailTypeKey = []byte(`"detail-type"`)
+
+// unwrapEventBridge returns the CloudTrail event inside of an EventBridge event along with the envelope metadata, ok
+// is false if event isn't an EventBridge event for a CloudTrail record.
+//
+// CloudTrail events are sent with a few different detail types, "AWS API Call via CloudTrail",
+// "AWS Console Sign In via CloudTrail", etc.
+func unwrapEventBridge(event json.RawMessage) (rec Record, ok bool) {
+	// Avoid decoding every event twice when reading plain CloudTrail records.
+	if !bytes.Contains(event, detailTypeKey) {
+		return Record{}, false
+	}
+
+	var e eventBridgeEvent
+	if err := json.Unmarshal(event, &e); err != nil {
+		return Record{}, false
+	}
+
+	if !isCloudTrailDetailType(e.DetailType) || len(e.Detail) == 0 {
+		return Record{}, false
+	}
+
+	return Record{
+		Event: e.Detail,
+		Envelope: &Envelope{
+			Id:         e.Id,
+			DetailType: e.DetailType,
+			Source:     e.Source,
+			Account:    e.Account,
+			Region:     e.Region,
+			Time:       e.Time,
+			Resources:  e.Resources,
+		},
+	}, true
+}
+
+func isCloudTrailDetailType(detailType string) bool {
+	return strings.HasSuffix(detailType, "via CloudTrail")
+}
+
+// EventBridge reads newline delimited EventBridge events, such as the ones stored in an EventBridge archive.
+//
+// Events for things other than CloudTrail records are skipped, an archive may be shared with other rules. Anything
+// that isn't an EventBridge event is passed through like it would be by Lines.
+type EventBridge struct {
+	dec *json.Decoder
+}
+
+func NewEventBridge(r io.Reader) *EventBridge {
+	return &EventBridge{dec: json.NewDecoder(r)}
+}
+
+func (e *EventBridge) Next() (Record, error) {
+	for {
+		var event json.RawMessage
+		if err := e.dec.Decode(&event); err == io.EOF {
+			return Record{}, io.EOF
+		} else if err != nil {
+			return Record{}, fmt.Errorf("eventbridge: decode: %w", err)
+		}
+
+		if rec, ok := unwrapEventBridge(event); ok {
+			return rec, nil
+		}
+
+		var envelope struct {
+			DetailType *string `json:"detail-type"`
+		}
+		if err := json.Unmarshal(event, &envelope); err != nil {
+			return Record{}, fmt.Errorf("eventbridge: unmarshal: %w", err)
+		} else if envelope.DetailType == nil {
+			// Not an EventBridge event, handle it the same way Lines would.
+			return unwrap(event)
+		}
+	}
+}
+
+// unwrap returns the CloudTrail event in event, unwrapping it first if it's inside an EventBridge event or a
+// LookupEvents result.
+func unwrap(event json.RawMessage) (Record, error) {
+	if rec, ok := unwrapEventBridge(event); ok {
+		return rec, nil
+	}
+
+	if bytes.Contains(event, cloudTrailEventKey) {
+		detail, ok, err := lookupEventDetail(event)
+		if err != nil {
+			return Record{}, err
+		} else if ok {
+			return Record{Event: detail}, nil
+		}
+	}
+	return Record{Event: event}, nil
+}
@@ -0,0 +1,59 @@
+package input
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLines_EventBridge(t *testing.T) {
+	data := `{"version": "0", "id": "11111111-1111-1111-1111-111111111111", "detail-type": "AWS API Call via CloudTrail", "source": "aws.sts", "account": "111111111111", "time": "2022-10-01T00:00:01Z", "region": "us-east-1", "resources": [], "detail": {"eventID": "1", "requestParameters": {"roleArn": "arn:aws:iam::111111111111:role/test"}}}
+{"eventID": "2", "detail-type": "not an envelope"}
+{"version": "0", "id": "22222222-2222-2222-2222-222222222222", "detail-type": "EC2 Instance State-change Notification", "source": "aws.ec2", "detail": {"instance-id": "i-11111111111111111"}}
+`
+
+	type result struct {
+		Event    string
+		Envelope *Envelope
+	}
+	want := []result{
+		{
+			Event: `{"eventID": "1", "requestParameters": {"roleArn": "arn:aws:iam::111111111111:role/test"}}`,
+			Envelope: &Envelope{
+				Id:         "11111111-1111-1111-1111-111111111111",
+				DetailType: "AWS API Call via CloudTrail",
+				Source:     "aws.sts",
+				Account:    "111111111111",
+				Region:     "us-east-1",
+				Time:       time.Date(2022, time.October, 1, 0, 0, 1, 0, time.UTC),
+				Resources:  []string{},
+			},
+		},
+		{
+			Event: `{"eventID": "2", "detail-type": "not an envelope"}`,
+		},
+		{
+			// Only events from CloudTrail are unwrapped.
+			Event: `{"version": "0", "id": "22222222-2222-2222-2222-222222222222", "detail-type": "EC2 Instance State-change Notification", "source": "aws.ec2", "detail": {"instance-id": "i-11111111111111111"}}`,
+		},
+	}
+
+	src := NewLines(bytes.NewBufferString(data))
+
+	var got []result
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		got = append(got, result{Event: string(rec.Event), Envelope: rec.Envelope})
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,84 @@
+package input
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Flags are the command line arguments for choosing and filtering input, shared by the commands that read
+// CloudTrail events.
+type Flags struct {
+	Format   string
+	Follow   bool
+	Queue    string
+	Endpoint string
+	Accounts string
+	Regions  string
+	Start    string
+	End      string
+}
+
+// Register adds the input arguments to fs.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Format, "format", "", fmt.Sprintf("Input format, one of %s (default detected from the input)", strings.Join(Formats(), ", ")))
+	fs.BoolVar(&f.Follow, "follow", false, "Keep waiting for new events, like tail -F, instead of exiting at the end of the input")
+	fs.StringVar(&f.Queue, "queue", "", "Read events from this SQS queue URL instead of files, messages can be S3 notifications or EventBridge events")
+	fs.StringVar(&f.Endpoint, "endpoint", "", "Override the AWS endpoint used with -queue, for example to test against a local SQS stand in")
+	fs.StringVar(&f.Accounts, "accounts", "", "Comma seperated list of accounts to read when given a log directory")
+	fs.StringVar(&f.Regions, "regions", "", "Comma seperated list of regions to read when given a log directory")
+	fs.StringVar(&f.Start, "start", "", "First day (YYYY-MM-DD) to read when given a log directory")
+	fs.StringVar(&f.End, "end", "", "Last day (YYYY-MM-DD) to read when given a log directory")
+}
+
+// Options returns the input options for the -format, -accounts, -regions, -start and -end arguments.
+func (f Flags) Options() (opts Options, err error) {
+	if f.Format != "" {
+		if _, ok := Lookup(f.Format); !ok {
+			return opts, formatError(f.Format)
+		}
+		opts.Format = f.Format
+	}
+
+	if f.Accounts != "" {
+		opts.Filter.Accounts = strings.Split(f.Accounts, ",")
+	}
+	if f.Regions != "" {
+		opts.Filter.Regions = strings.Split(f.Regions, ",")
+	}
+	if f.Start != "" {
+		opts.Filter.Start, err = time.Parse("2006-01-02", f.Start)
+		if err != nil {
+			return opts, fmt.Errorf("parsing -start: %w", err)
+		}
+	}
+	if f.End != "" {
+		opts.Filter.End, err = time.Parse("2006-01-02", f.End)
+		if err != nil {
+			return opts, fmt.Errorf("parsing -end: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// Open returns the input for paths based on the arguments.
+func (f Flags) Open(ctx context.Context, paths []string) (Source, error) {
+	if f.Queue != "" {
+		if len(paths) > 0 {
+			return nil, fmt.Errorf("paths can't be used with -queue")
+		}
+		return OpenQueue(ctx, f.Queue, f.Endpoint)
+	}
+
+	opts, err := f.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Follow {
+		return FollowAll(paths, opts)
+	}
+	return OpenAll(paths, opts)
+}
@@ -0,0 +1,343 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PollInterval is how often followed files and directories are checked for changes.
+const PollInterval = 5 * time.Second
+
+// Follow returns a Source like Open, except it keeps waiting for new events rather than stopping once it runs out.
+//
+// Files are followed the way tail -F does, appended events are read as they are written and the file is reopened if
+// it's truncated or replaced. Files being followed are expected to contain newline delimited events. Directories are
+// polled for new log files, which are read in the order they were delivered.
+//
+// The returned Source implements io.Closer, after Close is called Next returns io.EOF.
+func Follow(path string, opts Options) (Source, error) {
+	if path == "" || path == "-" {
+		return NewFormatReader(os.Stdin, opts.Format)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	interval := opts.Interval
+	if interval == 0 {
+		interval = PollInterval
+	}
+
+	f := &follower{interval: interval, done: make(chan struct{})}
+	if info.IsDir() {
+		return &followDir{follower: f, root: path, opts: opts, seen: map[string]bool{}, since: map[string]time.Time{}}, nil
+	}
+	return &followFile{follower: f, path: path}, nil
+}
+
+// FollowAll follows each of paths, events are returned from whichever path has one available first.
+//
+// Merging on eventTime isn't possible here, a quiet source would block the others indefinitely.
+func FollowAll(paths []string, opts Options) (Source, error) {
+	if len(paths) == 0 {
+		return Follow("", opts)
+	}
+
+	var sources []Source
+	for _, path := range paths {
+		src, err := Follow(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+	return fanIn(sources), nil
+}
+
+// follower is shared by the followed sources for waiting between polls.
+type follower struct {
+	interval  time.Duration
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// wait blocks until the next poll, io.EOF is returned if the source was closed while waiting.
+func (f *follower) wait() error {
+	select {
+	case <-time.After(f.interval):
+		return nil
+	case <-f.done:
+		return io.EOF
+	}
+}
+
+// closed reports whether Close has been called.
+func (f *follower) closed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *follower) Close() error {
+	f.closeOnce.Do(func() { close(f.done) })
+	return nil
+}
+
+// followFile reads newline delimited events from a file which is being written to.
+type followFile struct {
+	*follower
+	path string
+
+	f      *os.File
+	r      *bufio.Reader
+	info   os.FileInfo
+	offset int64
+
+	// partial holds the start of a line that hasn't been completely written yet.
+	partial []byte
+
+	// replaced is set once path points to a new file, the old one is read to the end before switching to it.
+	replaced bool
+}
+
+func (f *followFile) Next() (Record, error) {
+	for {
+		if f.closed() {
+			if f.f != nil {
+				f.f.Close()
+				f.f = nil
+			}
+			return Record{}, io.EOF
+		}
+
+		if f.f == nil {
+			if err := f.open(); os.IsNotExist(err) {
+				// Rotated and not recreated yet.
+				if err := f.wait(); err != nil {
+					return Record{}, err
+				}
+				continue
+			} else if err != nil {
+				return Record{}, err
+			}
+		}
+
+		line, err := f.r.ReadBytes('\n')
+		f.offset += int64(len(line))
+
+		if err == io.EOF {
+			f.partial = append(f.partial, line...)
+
+			if !f.replaced {
+				if err := f.wait(); err != nil {
+					f.f.Close()
+					f.f = nil
+					return Record{}, err
+				}
+				if err := f.reopen(); err != nil {
+					return Record{}, err
+				}
+				continue
+			}
+
+			// Everything written to the old file has been read, nothing else will be added to its last line so
+			// it's complete even without a newline.
+			line, f.partial = f.partial, nil
+			f.f.Close()
+			f.f = nil
+		} else if err != nil {
+			return Record{}, fmt.Errorf("follow: reading %s: %w", f.path, err)
+		}
+
+		line = bytes.TrimSpace(append(f.partial, line...))
+		f.partial = nil
+
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return Record{}, fmt.Errorf("follow: %s: invalid event at offset %d", f.path, f.offset)
+		}
+		return unwrap(line)
+	}
+}
+
+func (f *followFile) open() (err error) {
+	f.f, err = os.Open(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.info, err = f.f.Stat()
+	if err != nil {
+		f.f.Close()
+		f.f = nil
+		return fmt.Errorf("follow: stat %s: %w", f.path, err)
+	}
+
+	f.r = bufio.NewReader(f.f)
+	f.offset = 0
+	f.partial = nil
+	f.replaced = false
+	return nil
+}
+
+// reopen checks whether the file was truncated or replaced since we last read from it.
+func (f *followFile) reopen() error {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		// Moved away but nothing new in its place yet, keep reading from the old file until there is.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("follow: stat %s: %w", f.path, err)
+	}
+
+	if !os.SameFile(f.info, info) {
+		f.replaced = true
+		return nil
+	}
+
+	if info.Size() < f.offset {
+		if _, err := f.f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("follow: seek %s: %w", f.path, err)
+		}
+		f.r.Reset(f.f)
+		f.offset = 0
+		f.partial = nil
+	}
+	return nil
+}
+
+// followDir reads log files under a directory, polling for new ones once it runs out.
+type followDir struct {
+	*follower
+	root string
+	opts Options
+
+	seen map[string]bool
+	cur  Source
+
+	// since is the latest day seen in each region directory, earlier days aren't walked again. CloudTrail only
+	// delivers to the directory for the current day.
+	since map[string]time.Time
+}
+
+func (d *followDir) Next() (Record, error) {
+	for {
+		if d.cur != nil {
+			rec, err := d.cur.Next()
+			if err != io.EOF {
+				return rec, err
+			}
+			d.cur = nil
+		}
+
+		files, err := walk(d.root, d.opts.Filter, d.since)
+		if err != nil {
+			return Record{}, err
+		}
+
+		var unseen []LogFile
+		for _, file := range files {
+			if !d.seen[file.Path] {
+				d.seen[file.Path] = true
+				unseen = append(unseen, file)
+			}
+
+			if rel, err := filepath.Rel(d.root, file.Path); err == nil {
+				if dir := regionDir(rel); file.Date.After(d.since[dir]) {
+					d.since[dir] = file.Date
+				}
+			}
+		}
+
+		if len(unseen) > 0 {
+			d.cur = openFiles(unseen, d.opts.Format)
+			continue
+		}
+
+		if err := d.wait(); err != nil {
+			return Record{}, err
+		}
+	}
+}
+
+// fanIn returns events from sources as soon as any of them has one.
+func fanIn(sources []Source) Source {
+	f := &fannedIn{sources: sources, ch: make(chan item), done: make(chan struct{})}
+	f.wg.Add(len(sources))
+
+	for _, src := range sources {
+		go f.read(src)
+	}
+	go func() {
+		f.wg.Wait()
+		close(f.ch)
+	}()
+	return f
+}
+
+type fannedIn struct {
+	sources   []Source
+	ch        chan item
+	wg        sync.WaitGroup
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (f *fannedIn) read(src Source) {
+	defer f.wg.Done()
+
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			return
+		}
+
+		select {
+		case f.ch <- item{rec: rec, err: err}:
+		case <-f.done:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fannedIn) Next() (Record, error) {
+	it, ok := <-f.ch
+	if !ok {
+		return Record{}, io.EOF
+	}
+	return it.rec, it.err
+}
+
+// Close closes each of the sources being read from.
+func (f *fannedIn) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+		for _, src := range f.sources {
+			if c, ok := src.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	})
+	return nil
+}
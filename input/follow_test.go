@@ -0,0 +1,154 @@
+package input
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testInterval = 10 * time.Millisecond
+
+// nextId returns the eventID of the next event from src, failing the test if one doesn't show up in time.
+func nextId(t *testing.T, src Source) string {
+	t.Helper()
+
+	type result struct {
+		rec Record
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		rec, err := src.Next()
+		ch <- result{rec, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatalf("Next() error = %v", r.err)
+		}
+		var key eventKey
+		if err := json.Unmarshal(r.rec.Event, &key); err != nil {
+			t.Fatal(err)
+		}
+		return key.EventID
+	case <-time.After(2 * time.Second):
+		t.Fatal("Next() timed out")
+		return ""
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectIds(t *testing.T, src Source, want ...string) {
+	t.Helper()
+
+	for _, id := range want {
+		if got := nextId(t, src); got != id {
+			t.Fatalf("Next() eventID = %s, want %s", got, id)
+		}
+	}
+}
+
+func TestFollow_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	appendFile(t, path, event("1", 1)+"\n")
+
+	src, err := Follow(path, Options{Interval: testInterval})
+	if err != nil {
+		t.Fatalf("Follow() error = %v", err)
+	}
+	defer src.(io.Closer).Close()
+
+	expectIds(t, src, "1")
+
+	// Appended, including a line that is written in two parts.
+	line := event("3", 3) + "\n"
+	appendFile(t, path, event("2", 2)+"\n"+line[:10])
+	expectIds(t, src, "2")
+	appendFile(t, path, line[10:])
+	expectIds(t, src, "3")
+
+	// Truncated
+	if err := os.WriteFile(path, []byte(event("4", 4)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectIds(t, src, "4")
+
+	// Rotated, events written just before the rename are still read from the old file, including a last line
+	// without a newline.
+	appendFile(t, path, event("5", 5)+"\n"+event("6", 6))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, event("7", 7)+"\n")
+	expectIds(t, src, "5", "6", "7")
+}
+
+func TestFollow_Directory(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "AWSLogs", "111111111111", "CloudTrail", "us-east-1", "2022", "10", "01")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	records := func(ids ...string) string {
+		data := `{"Records": [`
+		for i, id := range ids {
+			if i > 0 {
+				data += ","
+			}
+			data += event(id, i)
+		}
+		return data + "]}"
+	}
+	appendFile(t, filepath.Join(dir, "111111111111_CloudTrail_us-east-1_20221001T0005Z_a.json"), records("1", "2"))
+
+	src, err := Follow(root, Options{Interval: testInterval})
+	if err != nil {
+		t.Fatalf("Follow() error = %v", err)
+	}
+	defer src.(io.Closer).Close()
+
+	expectIds(t, src, "1", "2")
+
+	appendFile(t, filepath.Join(dir, "111111111111_CloudTrail_us-east-1_20221001T0010Z_b.json"), records("3"))
+	expectIds(t, src, "3")
+}
+
+func TestFollow_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	appendFile(t, path, "")
+
+	src, err := Follow(path, Options{Interval: testInterval})
+	if err != nil {
+		t.Fatalf("Follow() error = %v", err)
+	}
+
+	go func() {
+		time.Sleep(5 * testInterval)
+		src.(io.Closer).Close()
+	}()
+
+	if _, err := src.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want io.EOF", err)
+	}
+	if _, err := src.Next(); err != io.EOF {
+		t.Errorf("Next() after Close() error = %v, want io.EOF", err)
+	}
+}
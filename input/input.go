@@ -0,0 +1,190 @@
+// Package input reads CloudTrail events from the formats CloudTrail logs tend to end up in.
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Source returns CloudTrail events one at a time, io.EOF is returned once the source is exhausted.
+type Source interface {
+	Next() (Record, error)
+}
+
+// Record is a single CloudTrail event read from a Source.
+type Record struct {
+	// Event is the CloudTrail event as it was stored in the input.
+	Event json.RawMessage
+
+	// Envelope is set when the event was delivered inside of an EventBridge event.
+	Envelope *Envelope
+
+	// Ack is set by sources that need to know when an event has been fully processed, for example to remove it from
+	// a queue. It must be called once the event has been written, or intentionally skipped.
+	Ack func() error
+}
+
+// Ack calls r.Ack if it is set.
+func Ack(r Record) error {
+	if r.Ack == nil {
+		return nil
+	}
+	return r.Ack()
+}
+
+// sniffLen is how much of the stream we look at to figure out what we're reading.
+const sniffLen = 512
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Options control how inputs are opened and read.
+type Options struct {
+	// Filter limits the log files read from directories.
+	Filter Filter
+
+	// Format is the name of the Decoder to use, if it's empty the format is detected from the input.
+	Format string
+
+	// Interval is how often followed inputs are polled for changes, PollInterval is used if this isn't set.
+	Interval time.Duration
+}
+
+// Open returns a Source for path, which can be a single log file, a directory containing the AWSLogs layout, or
+// empty/"-" for stdin.
+func Open(path string, opts Options) (Source, error) {
+	if path == "" || path == "-" {
+		return NewFormatReader(os.Stdin, opts.Format)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	if info.IsDir() {
+		return OpenDirectory(path, opts)
+	}
+	return &chain{files: []LogFile{{Path: path}}, format: opts.Format}, nil
+}
+
+// OpenAll opens each path with Open and merges the results on eventTime, stdin is used if paths is empty.
+func OpenAll(paths []string, opts Options) (Source, error) {
+	if len(paths) == 0 {
+		return Open("", opts)
+	}
+
+	var sources []Source
+	for _, path := range paths {
+		src, err := Open(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	return Merge(sources...), nil
+}
+
+// NewReader returns a Source for the events in r, detecting the format from the start of the input.
+//
+// Gzipped input is decompressed transparently. See Formats for the supported formats, in each case events are
+// decoded one at a time so the full input never needs to be held in memory.
+func NewReader(r io.Reader) (Source, error) {
+	return NewFormatReader(r, "")
+}
+
+// NewFormatReader returns a Source for the events in r using the decoder for format, the format is detected from
+// the input if it's empty.
+func NewFormatReader(r io.Reader, format string) (Source, error) {
+	var dec Decoder
+	if format != "" {
+		var ok bool
+		if dec, ok = Lookup(format); !ok {
+			return nil, formatError(format)
+		}
+	}
+
+	buf := bufio.NewReader(r)
+
+	magic, err := buf.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("input: peek: %w", err)
+	}
+
+	if bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("input: gzip: %w", err)
+		}
+		buf = bufio.NewReader(gz)
+	}
+
+	if dec == nil {
+		// Peek returns what it can along with an error if the stream is shorter than sniffLen, which is fine here.
+		head, err := buf.Peek(sniffLen)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, fmt.Errorf("input: peek: %w", err)
+		}
+		dec = Detect(head)
+	}
+	return dec.Decode(buf), nil
+}
+
+// Lines reads newline delimited CloudTrail events, each line can also be an EventBridge event such as the ones
+// stored in an EventBridge archive, or a single LookupEvents result.
+type Lines struct {
+	dec *json.Decoder
+}
+
+func NewLines(r io.Reader) *Lines {
+	return &Lines{dec: json.NewDecoder(r)}
+}
+
+func (l *Lines) Next() (Record, error) {
+	var event json.RawMessage
+	if err := l.dec.Decode(&event); err != nil {
+		if err == io.EOF {
+			return Record{}, err
+		}
+		return Record{}, fmt.Errorf("lines: decode: %w", err)
+	}
+	return unwrap(event)
+}
+
+// Array reads a JSON array of events. Like Lines, elements can also be EventBridge events or LookupEvents results.
+type Array struct {
+	dec     *json.Decoder
+	started bool
+}
+
+func NewArray(r io.Reader) *Array {
+	return &Array{dec: json.NewDecoder(r)}
+}
+
+func (a *Array) Next() (Record, error) {
+	if !a.started {
+		a.started = true
+		if tok, err := a.dec.Token(); err == io.EOF {
+			return Record{}, io.EOF
+		} else if err != nil {
+			return Record{}, fmt.Errorf("array: read token: %w", err)
+		} else if tok != json.Delim('[') {
+			return Record{}, fmt.Errorf("array: expected an array, got %v", tok)
+		}
+	}
+
+	if !a.dec.More() {
+		return Record{}, io.EOF
+	}
+
+	var event json.RawMessage
+	if err := a.dec.Decode(&event); err != nil {
+		return Record{}, fmt.Errorf("array: decode: %w", err)
+	}
+	return unwrap(event)
+}
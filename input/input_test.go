@@ -0,0 +1,100 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"testing"
+)
+
+func gz(t *testing.T, data string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func readAll(t *testing.T, src Source) (events []string) {
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			return events
+		} else if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, rec.Event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, compact.String())
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	records := `{"Records": [
+		{"eventID": "1", "eventName": "AssumeRole"},
+		{"eventID": "2", "eventName": "GetCallerIdentity"}
+	]}`
+
+	tests := []struct {
+		name string
+		data func(t *testing.T) string
+		want []string
+	}{
+		{
+			name: "lines",
+			data: func(t *testing.T) string {
+				return "{\"eventID\": \"1\"}\n\n{\"eventID\": \"2\"}\n"
+			},
+			want: []string{`{"eventID":"1"}`, `{"eventID":"2"}`},
+		},
+		{
+			name: "records",
+			data: func(t *testing.T) string { return records },
+			want: []string{`{"eventID":"1","eventName":"AssumeRole"}`, `{"eventID":"2","eventName":"GetCallerIdentity"}`},
+		},
+		{
+			name: "gzipped_records",
+			data: func(t *testing.T) string { return gz(t, records) },
+			want: []string{`{"eventID":"1","eventName":"AssumeRole"}`, `{"eventID":"2","eventName":"GetCallerIdentity"}`},
+		},
+		{
+			name: "gzipped_lines",
+			data: func(t *testing.T) string { return gz(t, "{\"eventID\": \"1\"}\n") },
+			want: []string{`{"eventID":"1"}`},
+		},
+		{
+			name: "concatenated_records_with_other_keys",
+			data: func(t *testing.T) string {
+				return `{"Records": [{"eventID": "1"}], "other": {"a": [1, 2]}}` + "\n" +
+					`{"other": true, "Records": []}` + "\n" +
+					`{"Records": [{"eventID": "2"}]}`
+			},
+			want: []string{`{"eventID":"1"}`, `{"eventID":"2"}`},
+		},
+		{
+			name: "empty",
+			data: func(t *testing.T) string { return "" },
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewReader(bytes.NewBufferString(tt.data(t)))
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, readAll(t, src)); diff != "" {
+				t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
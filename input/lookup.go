@@ -0,0 +1,147 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// LookupEvents reads the output of the CloudTrail LookupEvents API, for example from
+// `aws cloudtrail lookup-events`. The actual CloudTrail event is stored as a JSON string in each result's
+// CloudTrailEvent key.
+type LookupEvents struct {
+	events *Records
+}
+
+type lookupEvent struct {
+	EventId         string `json:"EventId"`
+	CloudTrailEvent string `json:"CloudTrailEvent"`
+}
+
+func NewLookupEvents(r io.Reader) *LookupEvents {
+	return &LookupEvents{events: newArrayStream(r, "Events")}
+}
+
+func (l *LookupEvents) Next() (Record, error) {
+	rec, err := l.events.Next()
+	if err != nil {
+		return Record{}, err
+	}
+
+	event, ok, err := lookupEventDetail(rec.Event)
+	if err != nil {
+		return Record{}, err
+	} else if !ok {
+		return Record{}, fmt.Errorf("lookup: result is missing CloudTrailEvent")
+	}
+	return Record{Event: event}, nil
+}
+
+var cloudTrailEventKey = []byte(`"CloudTrailEvent"`)
+
+// lookupEventDetail returns the CloudTrail event embedded in a LookupEvents result, ok is false if data doesn't have
+// a CloudTrailEvent key.
+func lookupEventDetail(data json.RawMessage) (event json.RawMessage, ok bool, err error) {
+	var e lookupEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, false, fmt.Errorf("lookup: decode: %w", err)
+	}
+
+	if e.CloudTrailEvent == "" {
+		return nil, false, nil
+	}
+
+	event = json.RawMessage(e.CloudTrailEvent)
+	if !json.Valid(event) {
+		return nil, false, fmt.Errorf("lookup: event %s: CloudTrailEvent isn't valid JSON", e.EventId)
+	}
+	return event, true, nil
+}
+
+// CloudTrailLake reads the output of the CloudTrail Lake GetQueryResults API, for example from
+// `aws cloudtrail get-query-results`.
+//
+// Each result row is a list of single column objects, these are put back together in to a single event. Column
+// values that are JSON objects or arrays are kept as is, which means nested fields like userIdentity need to be
+// selected as JSON to be of any use here. If a row only has a single column containing an object, that object is
+// used as the event.
+type CloudTrailLake struct {
+	rows *Records
+}
+
+// lakeTimeLayout is the format CloudTrail Lake returns timestamps in.
+const lakeTimeLayout = "2006-01-02 15:04:05.000"
+
+func NewCloudTrailLake(r io.Reader) *CloudTrailLake {
+	return &CloudTrailLake{rows: newArrayStream(r, "QueryResultRows")}
+}
+
+func (l *CloudTrailLake) Next() (Record, error) {
+	rec, err := l.rows.Next()
+	if err != nil {
+		return Record{}, err
+	}
+
+	var row []map[string]string
+	if err := json.Unmarshal(rec.Event, &row); err != nil {
+		return Record{}, fmt.Errorf("lake: decode row: %w", err)
+	}
+
+	event, err := lakeEvent(row)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Event: event}, nil
+}
+
+func lakeEvent(row []map[string]string) (json.RawMessage, error) {
+	if len(row) == 1 {
+		for _, value := range row[0] {
+			if isJSONContainer(value) {
+				return json.RawMessage(value), nil
+			}
+		}
+	}
+
+	event := map[string]json.RawMessage{}
+	for _, column := range row {
+		for name, value := range column {
+			if name == "eventTime" {
+				if t, err := time.Parse(lakeTimeLayout, value); err == nil {
+					value = t.UTC().Format(time.RFC3339)
+				}
+			}
+
+			if isJSONContainer(value) {
+				event[name] = json.RawMessage(value)
+				continue
+			}
+
+			// Lake returns everything as a string, the only top level fields that aren't strings in CloudTrail events
+			// are booleans (readOnly, managementEvent, etc.).
+			if value == "true" || value == "false" {
+				event[name] = json.RawMessage(value)
+				continue
+			}
+
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("lake: column %s: %w", name, err)
+			}
+			event[name] = raw
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("lake: encode row: %w", err)
+	}
+	return data, nil
+}
+
+func isJSONContainer(value string) bool {
+	v := bytes.TrimSpace([]byte(value))
+	return len(v) > 0 && (v[0] == '{' || v[0] == '[') && json.Valid(v)
+}
@@ -0,0 +1,101 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func lookupEventJSON(t *testing.T, id string, second int) string {
+	data, err := json.Marshal(map[string]interface{}{
+		"EventId":         id,
+		"EventName":       "GetCallerIdentity",
+		"ReadOnly":        "true",
+		"EventSource":     "sts.amazonaws.com",
+		"CloudTrailEvent": event(id, second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNewReader_LookupEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		data func(t *testing.T) string
+		want []string
+	}{
+		{
+			name: "lookup_events",
+			data: func(t *testing.T) string {
+				return fmt.Sprintf(`{"Events": [%s, %s], "NextToken": "token"}`, lookupEventJSON(t, "1", 1), lookupEventJSON(t, "2", 2))
+			},
+			want: []string{"1", "2"},
+		},
+		{
+			// Results that have been split out with something like jq -c '.Events[]'
+			name: "lines",
+			data: func(t *testing.T) string {
+				return lookupEventJSON(t, "1", 1) + "\n" + lookupEventJSON(t, "2", 2) + "\n"
+			},
+			want: []string{"1", "2"},
+		},
+		{
+			name: "lake",
+			data: func(t *testing.T) string {
+				return `{
+					"QueryStatus": "FINISHED",
+					"QueryStatistics": {"ResultsCount": 2, "TotalResultsCount": 2},
+					"QueryResultRows": [
+						[{"eventID": "1"}, {"eventTime": "2022-10-01 00:00:01.000"}, {"userIdentity": "{\"type\": \"IAMUser\"}"}],
+						[{"eventJson": ` + fmt.Sprintf("%q", event("2", 2)) + `}]
+					]
+				}`
+			},
+			want: []string{"1", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewReader(bytes.NewBufferString(tt.data(t)))
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, eventIds(t, src)); diff != "" {
+				t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLakeEvent(t *testing.T) {
+	got, err := lakeEvent([]map[string]string{
+		{"eventID": "1"},
+		{"eventTime": "2022-10-01 00:00:01.000"},
+		{"userIdentity": `{"type": "IAMUser", "userName": "test"}`},
+		{"resources": `[{"ARN": "arn:aws:iam::111111111111:role/test"}]`},
+		{"readOnly": "true"},
+	})
+	if err != nil {
+		t.Fatalf("lakeEvent() error = %v", err)
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(got, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"eventID":      "1",
+		"eventTime":    "2022-10-01T00:00:01Z",
+		"userIdentity": map[string]interface{}{"type": "IAMUser", "userName": "test"},
+		"resources":    []interface{}{map[string]interface{}{"ARN": "arn:aws:iam::111111111111:role/test"}},
+		"readOnly":     true,
+	}
+	if diff := cmp.Diff(want, event); diff != "" {
+		t.Errorf("lakeEvent() mismatch (-want +got):\n%s", diff)
+	}
+}
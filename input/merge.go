@@ -0,0 +1,163 @@
+package input
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MergeBuffer is the number of events read ahead from each source being merged.
+//
+// This bounds the memory used per source, a slow consumer will block the readers rather than let them run ahead.
+const MergeBuffer = 64
+
+// eventKey is the part of an event we need for ordering.
+type eventKey struct {
+	EventTime time.Time `json:"eventTime"`
+	EventID   string    `json:"eventID"`
+}
+
+func (k eventKey) Less(o eventKey) bool {
+	if !k.EventTime.Equal(o.EventTime) {
+		return k.EventTime.Before(o.EventTime)
+	}
+	return k.EventID < o.EventID
+}
+
+// item is an event read from a source, or the error that stopped the source.
+type item struct {
+	rec Record
+	key eventKey
+	err error
+}
+
+type head struct {
+	item
+	source int
+}
+
+type heads []head
+
+func (h heads) Len() int { return len(h) }
+func (h heads) Less(i, j int) bool {
+	if h[i].key == h[j].key {
+		return h[i].source < h[j].source
+	}
+	return h[i].key.Less(h[j].key)
+}
+func (h heads) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *heads) Push(x interface{}) { *h = append(*h, x.(head)) }
+func (h *heads) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Merge returns a Source which reads from all sources concurrently, returning the earliest event available across
+// them each time. Events with the same eventTime are ordered on eventID, and then on the order of sources.
+//
+// Each source is expected to be more or less in order already, Merge doesn't reorder events within a source.
+func Merge(sources ...Source) Source {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+
+	m := &merged{
+		chans: make([]chan item, len(sources)),
+		done:  make(chan struct{}),
+	}
+	for i, src := range sources {
+		m.chans[i] = make(chan item, MergeBuffer)
+		go m.read(src, m.chans[i])
+	}
+	return m
+}
+
+type merged struct {
+	chans   []chan item
+	heads   heads
+	started bool
+
+	// err stopped one of the sources, it's returned once the event popped before it was read has been.
+	err error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// read sends events from src to ch until src is exhausted or the merge is closed.
+func (m *merged) read(src Source, ch chan<- item) {
+	defer close(ch)
+
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			return
+		}
+
+		it := item{rec: rec, err: err}
+		if err == nil {
+			if err := json.Unmarshal(rec.Event, &it.key); err != nil {
+				it.err = fmt.Errorf("merge: reading eventTime: %w", err)
+			}
+		}
+
+		select {
+		case ch <- it:
+		case <-m.done:
+			return
+		}
+
+		if it.err != nil {
+			return
+		}
+	}
+}
+
+func (m *merged) Next() (Record, error) {
+	if m.err != nil {
+		return Record{}, m.err
+	}
+	if !m.started {
+		m.started = true
+		for i := range m.chans {
+			if err := m.fill(i); err != nil {
+				return Record{}, err
+			}
+		}
+	}
+
+	if m.heads.Len() == 0 {
+		return Record{}, io.EOF
+	}
+
+	h := heap.Pop(&m.heads).(head)
+	if err := m.fill(h.source); err != nil {
+		m.err = err
+	}
+	return h.rec, nil
+}
+
+// fill waits for the next event from source i and pushes it on to the heap.
+func (m *merged) fill(i int) error {
+	it, ok := <-m.chans[i]
+	if !ok {
+		return nil
+	} else if it.err != nil {
+		m.Close()
+		return it.err
+	}
+
+	heap.Push(&m.heads, head{item: it, source: i})
+	return nil
+}
+
+// Close stops the goroutines reading from the merged sources.
+func (m *merged) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return nil
+}
@@ -0,0 +1,134 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"testing"
+	"time"
+)
+
+// events is a Source returning a fixed list of events.
+type events []string
+
+func (e *events) Next() (Record, error) {
+	if len(*e) == 0 {
+		return Record{}, io.EOF
+	}
+	event := (*e)[0]
+	*e = (*e)[1:]
+	return Record{Event: json.RawMessage(event)}, nil
+}
+
+func event(id string, second int) string {
+	eventTime := time.Date(2022, time.October, 1, 0, 0, second, 0, time.UTC)
+	return fmt.Sprintf(`{"eventID": "%s", "eventTime": "%s"}`, id, eventTime.Format(time.RFC3339))
+}
+
+type failing struct{}
+
+func (failing) Next() (Record, error) { return Record{}, errors.New("failed") }
+
+// failsAfter returns its events and then fails.
+type failsAfter []string
+
+func (f *failsAfter) Next() (Record, error) {
+	if len(*f) == 0 {
+		return Record{}, errors.New("failed")
+	}
+	event := (*f)[0]
+	*f = (*f)[1:]
+	return Record{Event: json.RawMessage(event)}, nil
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources [][]string
+		want    []string
+	}{
+		{
+			name: "interleaved",
+			sources: [][]string{
+				{event("a", 1), event("c", 3), event("e", 5)},
+				{event("b", 2), event("d", 4)},
+				{},
+				{event("f", 6)},
+			},
+			want: []string{"a", "b", "c", "d", "e", "f"},
+		},
+		{
+			name: "same_time_ordered_on_event_id",
+			sources: [][]string{
+				{event("b", 1), event("d", 2)},
+				{event("a", 1), event("c", 2)},
+			},
+			want: []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "longer_than_buffer",
+			sources: func() [][]string {
+				var a, b []string
+				for i := 0; i < MergeBuffer*2; i++ {
+					a = append(a, event(fmt.Sprintf("a%03d", i), i))
+					b = append(b, event(fmt.Sprintf("b%03d", i), i))
+				}
+				return [][]string{a, b}
+			}(),
+			want: func() (ids []string) {
+				for i := 0; i < MergeBuffer*2; i++ {
+					ids = append(ids, fmt.Sprintf("a%03d", i), fmt.Sprintf("b%03d", i))
+				}
+				return ids
+			}(),
+		},
+		{
+			name:    "none",
+			sources: nil,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sources []Source
+			for _, s := range tt.sources {
+				s := events(s)
+				sources = append(sources, &s)
+			}
+
+			if diff := cmp.Diff(tt.want, eventIds(t, Merge(sources...))); diff != "" {
+				t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMerge_Error(t *testing.T) {
+	ok := events{event("a", 1), event("b", 2)}
+	src := Merge(&ok, failing{})
+
+	if _, err := src.Next(); err == nil || err.Error() != "failed" {
+		t.Errorf("Next() error = %v, want failed", err)
+	}
+}
+
+// An event read before its source failed is still returned, the error comes after it.
+func TestMerge_ErrorAfterEvent(t *testing.T) {
+	failing := failsAfter{event("a", 1)}
+	ok := events{event("b", 2)}
+	src := Merge(&failing, &ok)
+
+	got, err := src.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if diff := cmp.Diff(event("a", 1), string(got.Event)); diff != "" {
+		t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := src.Next(); err == nil || err.Error() != "failed" {
+		t.Errorf("Next() error = %v, want failed", err)
+	}
+}
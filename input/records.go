@@ -0,0 +1,97 @@
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Records reads the {"Records": [...]} envelope CloudTrail uses for the log files it delivers to S3.
+//
+// The Records array is streamed rather than unmarshalled in one go, log files can be hundreds of MB uncompressed.
+// Multiple envelopes one after another are handled as well, this is what you get from zcat'ing several log files
+// together.
+type Records struct {
+	dec *json.Decoder
+
+	// key is the envelope key containing the array of events.
+	key string
+
+	// inRecords is set while the decoder is positioned inside of a Records array.
+	inRecords bool
+}
+
+func NewRecords(r io.Reader) *Records {
+	return newArrayStream(r, "Records")
+}
+
+// newArrayStream streams the array of events under key in one or more JSON objects, this works the same way as the
+// Records envelope does.
+func newArrayStream(r io.Reader, key string) *Records {
+	return &Records{dec: json.NewDecoder(r), key: key}
+}
+
+func (r *Records) Next() (Record, error) {
+	for {
+		if r.inRecords {
+			if r.dec.More() {
+				var event json.RawMessage
+				if err := r.dec.Decode(&event); err != nil {
+					return Record{}, fmt.Errorf("records: decode event: %w", err)
+				}
+				return Record{Event: event}, nil
+			}
+
+			// Consume the closing ']'
+			if _, err := r.dec.Token(); err != nil {
+				return Record{}, fmt.Errorf("records: end of array: %w", err)
+			}
+			r.inRecords = false
+		}
+
+		if err := r.seek(); err != nil {
+			return Record{}, err
+		}
+	}
+}
+
+// seek advances the decoder to the start of the next events array, skipping over any other keys in the envelope.
+func (r *Records) seek() error {
+	for {
+		tok, err := r.dec.Token()
+		if err == io.EOF {
+			return io.EOF
+		} else if err != nil {
+			return fmt.Errorf("records: read token: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case json.Delim:
+			// Start or end of an envelope, nothing to do here.
+			if t == '{' || t == '}' {
+				continue
+			}
+			return fmt.Errorf("records: unexpected %s in envelope", t)
+		case string:
+			if t != r.key {
+				var skip json.RawMessage
+				if err := r.dec.Decode(&skip); err != nil {
+					return fmt.Errorf("records: skipping %s: %w", t, err)
+				}
+				continue
+			}
+
+			tok, err := r.dec.Token()
+			if err != nil {
+				return fmt.Errorf("records: read token: %w", err)
+			}
+			if tok != json.Delim('[') {
+				return fmt.Errorf("records: expected %s to be an array, got %v", r.key, tok)
+			}
+			r.inRecords = true
+			return nil
+		default:
+			return fmt.Errorf("records: unexpected %v in envelope", t)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Decoder reads events stored in a particular format.
+type Decoder interface {
+	// Name identifies the format, this is what gets passed to -format.
+	Name() string
+
+	// Match reports whether head, the start of an input after any decompression, looks like this format.
+	Match(head []byte) bool
+
+	// Decode returns a Source for the events in r.
+	Decode(r io.Reader) Source
+}
+
+// decoder implements Decoder for the formats built in to this package.
+type decoder struct {
+	name   string
+	match  func(head []byte) bool
+	decode func(r io.Reader) Source
+}
+
+func (d decoder) Name() string              { return d.name }
+func (d decoder) Match(head []byte) bool    { return d.match(head) }
+func (d decoder) Decode(r io.Reader) Source { return d.decode(r) }
+
+func matchRe(re *regexp.Regexp) func([]byte) bool {
+	return re.Match
+}
+
+// fallback is used when nothing else matches the input.
+var fallback = decoder{
+	name:   "lines",
+	match:  func([]byte) bool { return false },
+	decode: func(r io.Reader) Source { return NewLines(r) },
+}
+
+var registry = struct {
+	sync.Mutex
+	decoders []Decoder
+}{
+	// Checked in order, the more specific formats need to come first.
+	decoders: []Decoder{
+		decoder{
+			name: "records",
+			// The envelope CloudTrail uses for the log files it writes to S3.
+			match:  matchRe(regexp.MustCompile(`^\s*{\s*"Records"\s*:`)),
+			decode: func(r io.Reader) Source { return NewRecords(r) },
+		},
+		decoder{
+			name: "cloudwatch",
+			// Any of the payload keys can come first depending on what produced it.
+			match:  matchRe(regexp.MustCompile(`^\s*{\s*"(messageType|owner|logGroup|logStream|subscriptionFilters|logEvents|awslogs)"\s*:`)),
+			decode: func(r io.Reader) Source { return NewCloudWatchLogs(r) },
+		},
+		decoder{
+			name:   "lookup",
+			match:  matchRe(regexp.MustCompile(`^\s*{\s*"Events"\s*:`)),
+			decode: func(r io.Reader) Source { return NewLookupEvents(r) },
+		},
+		decoder{
+			name:   "lake",
+			match:  matchRe(regexp.MustCompile(`^\s*{\s*"(QueryStatus|QueryStatistics|QueryResultRows)"\s*:`)),
+			decode: func(r io.Reader) Source { return NewCloudTrailLake(r) },
+		},
+		decoder{
+			name:   "eventbridge",
+			match:  matchEventBridge,
+			decode: func(r io.Reader) Source { return NewEventBridge(r) },
+		},
+		decoder{
+			name:   "array",
+			match:  matchRe(regexp.MustCompile(`^\s*\[`)),
+			decode: func(r io.Reader) Source { return NewArray(r) },
+		},
+		fallback,
+	},
+}
+
+// Register adds a decoder for a new input format, it's checked after the built-in formats when detecting the format
+// of an input. If Register is called twice with the same name or if d is nil, it panics.
+func Register(d Decoder) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if d == nil {
+		panic("input: Register decoder is nil")
+	}
+	for _, r := range registry.decoders {
+		if r.Name() == d.Name() {
+			panic("input: Register called twice for format " + d.Name())
+		}
+	}
+	registry.decoders = append(registry.decoders, d)
+}
+
+// Lookup returns the decoder for the format called name.
+func Lookup(name string) (Decoder, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, d := range registry.decoders {
+		if d.Name() == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// Formats returns the names of every registered format.
+func Formats() (names []string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, d := range registry.decoders {
+		names = append(names, d.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Detect returns the decoder for the input starting with head, newline delimited events are assumed if nothing else
+// matches.
+func Detect(head []byte) Decoder {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, d := range registry.decoders {
+		if d.Match(head) {
+			return d
+		}
+	}
+	return fallback
+}
+
+// matchEventBridge checks whether the first value in head is an EventBridge event for a CloudTrail record.
+func matchEventBridge(head []byte) bool {
+	if !bytes.Contains(head, detailTypeKey) {
+		return false
+	}
+
+	// head will usually end part way through the first event, so pull the detail-type out by hand.
+	dec := json.NewDecoder(bytes.NewReader(head))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return false
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return false
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return false
+		}
+
+		if key == "detail-type" {
+			var detailType string
+			return json.Unmarshal(value, &detailType) == nil && isCloudTrailDetailType(detailType)
+		}
+	}
+	return false
+}
+
+// formatError is returned when an unknown format is requested.
+func formatError(name string) error {
+	return fmt.Errorf("unknown format %s, expected one of %v", name, Formats())
+}
@@ -0,0 +1,139 @@
+package input
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{name: "records", head: `{"Records": [{"eventID": "1"}`, want: "records"},
+		{name: "cloudwatch", head: `{"messageType": "DATA_MESSAGE", "owner": "111111111111"`, want: "cloudwatch"},
+		{name: "cloudwatch lambda", head: `{"awslogs": {"data": "H4sI`, want: "cloudwatch"},
+		{name: "lookup", head: `{"Events": [{"EventId": "1"`, want: "lookup"},
+		{name: "lake", head: `{"QueryStatus": "FINISHED", "QueryResultRows": [`, want: "lake"},
+		{
+			name: "eventbridge",
+			head: `{"version": "0", "id": "1", "detail-type": "AWS API Call via CloudTrail", "source": "aws.sts", "detail": {"eventID": "1", "userIdent`,
+			want: "eventbridge",
+		},
+		{
+			name: "other eventbridge",
+			head: `{"version": "0", "id": "1", "detail-type": "EC2 Instance State-change Notification", "detail": {}}`,
+			want: "lines",
+		},
+		{name: "array", head: "\n[{\"eventID\": \"1\"}", want: "array"},
+		{name: "lines", head: `{"eventID": "1", "eventName": "AssumeRole"}`, want: "lines"},
+		{name: "empty", head: "", want: "lines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect([]byte(tt.head)).Name(); got != tt.want {
+				t.Errorf("Detect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFormatReader(t *testing.T) {
+	eventBridge := `{"version": "0", "id": "1", "detail-type": "AWS API Call via CloudTrail", "detail": {"eventID": "1"}}
+{"version": "0", "id": "2", "detail-type": "EC2 Instance State-change Notification", "detail": {"instance-id": "i-11111111111111111"}}
+{"eventID": "3"}
+`
+
+	tests := []struct {
+		name    string
+		format  string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "detect array",
+			data: `[{"eventID": "1"}, {"detail-type": "AWS API Call via CloudTrail", "detail": {"eventID": "2"}}]`,
+			want: []string{`{"eventID":"1"}`, `{"eventID":"2"}`},
+		},
+		{
+			name: "detect eventbridge",
+			data: eventBridge,
+			want: []string{`{"eventID":"1"}`, `{"eventID":"3"}`},
+		},
+		{
+			name:   "override",
+			format: "lines",
+			data:   eventBridge,
+			want: []string{
+				`{"eventID":"1"}`,
+				`{"version":"0","id":"2","detail-type":"EC2 Instance State-change Notification","detail":{"instance-id":"i-11111111111111111"}}`,
+				`{"eventID":"3"}`,
+			},
+		},
+		{
+			name:    "unknown format",
+			format:  "csv",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewFormatReader(strings.NewReader(tt.data), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFormatReader() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, readAll(t, src)); diff != "" {
+				t.Errorf("NewFormatReader() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// upper is a Decoder for testing Register, it matches input starting with "UPPER" and reads nothing.
+type upper struct{}
+
+func (upper) Name() string            { return "upper" }
+func (upper) Match(head []byte) bool  { return bytes.HasPrefix(head, []byte("UPPER")) }
+func (upper) Decode(io.Reader) Source { return NewLines(strings.NewReader("")) }
+
+func TestRegister(t *testing.T) {
+	registry.Lock()
+	saved := registry.decoders
+	registry.decoders = append([]Decoder{}, saved...)
+	registry.Unlock()
+
+	t.Cleanup(func() {
+		registry.Lock()
+		registry.decoders = saved
+		registry.Unlock()
+	})
+
+	Register(upper{})
+
+	if _, ok := Lookup("upper"); !ok {
+		t.Errorf("Lookup() did not find the registered decoder")
+	}
+	if got := Detect([]byte("UPPER")).Name(); got != "upper" {
+		t.Errorf("Detect() = %v, want upper", got)
+	}
+
+	want := []string{"array", "cloudwatch", "eventbridge", "lake", "lines", "lookup", "records", "upper"}
+	if diff := cmp.Diff(want, Formats()); diff != "" {
+		t.Errorf("Formats() mismatch (-want +got):\n%s", diff)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register() did not panic for a duplicate format")
+		}
+	}()
+	Register(upper{})
+}
@@ -0,0 +1,400 @@
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultVisibility is the visibility timeout messages are received with, it's extended every half of it for as
+// long as events from the message are still pending.
+const DefaultVisibility = time.Minute
+
+// SQSAPI is the part of the SQS client used by Queue.
+type SQSAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// S3API is the part of the S3 client used by Queue.
+type S3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// OpenQueue returns a Queue for queueUrl using the default AWS credential chain.
+//
+// If endpoint is set it's used for both SQS and S3 requests, this is mainly useful for testing against a local
+// stand in for AWS.
+func OpenQueue(ctx context.Context, queueUrl, endpoint string) (*Queue, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("queue: loading aws config: %w", err)
+	}
+
+	sqsClient := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = sqs.EndpointResolverFromURL(endpoint)
+		}
+	})
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return NewQueue(ctx, sqsClient, s3Client, queueUrl), nil
+}
+
+// NewQueue returns a Source which long polls an SQS queue for CloudTrail events.
+//
+// Messages can either be S3 event notifications for new log files, in which case the referenced objects are fetched
+// and decoded, or EventBridge events for individual CloudTrail records. A message is only deleted once every event
+// in it has been acknowledged with Record.Ack. Until then its visibility timeout is kept extended, so events held
+// for a while before being written aren't delivered again.
+//
+// S3 test events and EventBridge events for anything other than CloudTrail are deleted without doing anything. Other
+// messages that can't be read are reported to OnError and left on the queue, so they're delivered again and end up in
+// its dead-letter queue if one is configured.
+//
+// The returned Queue implements io.Closer, after Close is called Next returns io.EOF.
+func NewQueue(ctx context.Context, sqsClient SQSAPI, s3Client S3API, queueUrl string) *Queue {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Queue{
+		ctx:        ctx,
+		cancel:     cancel,
+		sqs:        sqsClient,
+		s3:         s3Client,
+		url:        queueUrl,
+		visibility: DefaultVisibility,
+		inflight:   map[string]bool{},
+	}
+}
+
+type Queue struct {
+	// OnError is called with problems that don't stop the queue, such as a message being left on the queue because
+	// it isn't an S3 notification or CloudTrail event. It may be called from another goroutine.
+	OnError func(error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	sqs    SQSAPI
+	s3     S3API
+	url    string
+
+	// visibility is the visibility timeout messages are received with, inflight are the receipt handles of the
+	// messages that haven't been deleted yet.
+	visibility time.Duration
+	extending  sync.Once
+	mu         sync.Mutex
+	inflight   map[string]bool
+
+	// messages received but not yet read from.
+	messages []sqstypes.Message
+
+	// msg is the message currently being read, objects are the S3 objects in it we haven't opened yet.
+	msg     *message
+	objects []s3Object
+
+	// cur and body are set while reading from an S3 object.
+	cur  Source
+	body io.Closer
+}
+
+type s3Object struct {
+	Bucket string
+	Key    string
+}
+
+// s3Notification is the message S3 sends for bucket event notifications.
+type s3Notification struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+		EventName   string `json:"eventName"`
+		S3          struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+
+	// Event is set to s3:TestEvent for the message sent when notifications are first configured.
+	Event string `json:"Event"`
+
+	// DetailType is set if the message is an EventBridge event instead.
+	DetailType *string `json:"detail-type"`
+}
+
+func (q *Queue) Next() (Record, error) {
+	for {
+		if q.cur != nil {
+			rec, err := q.cur.Next()
+			if err == io.EOF {
+				q.body.Close()
+				q.cur = nil
+				continue
+			} else if err != nil {
+				return Record{}, fmt.Errorf("queue: %w", err)
+			}
+
+			rec.Ack = q.msg.hold()
+			return rec, nil
+		}
+
+		if len(q.objects) > 0 {
+			if err := q.open(q.objects[0]); err != nil {
+				return Record{}, err
+			}
+			q.objects = q.objects[1:]
+			continue
+		}
+
+		if q.msg != nil {
+			if err := q.msg.finish(); err != nil {
+				return Record{}, err
+			}
+			q.msg = nil
+		}
+
+		if len(q.messages) == 0 {
+			if err := q.receive(); q.ctx.Err() != nil {
+				return Record{}, io.EOF
+			} else if err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		q.msg = &message{q: q, handle: m.ReceiptHandle, reading: true}
+
+		if rec, ok, err := q.parse(m); err != nil {
+			// Leave the message to be delivered again once its visibility timeout runs out, so it ends up in the
+			// dead-letter queue if there is one rather than being lost.
+			q.error(err)
+			q.msg.release()
+			q.msg = nil
+		} else if ok {
+			// There is only ever one event in these, so we're done with the message as soon as it's acknowledged.
+			rec.Ack = q.msg.hold()
+			if err := q.msg.finish(); err != nil {
+				return Record{}, err
+			}
+			q.msg = nil
+			return rec, nil
+		}
+	}
+}
+
+// parse reads the body of m, if it contained an event directly it is returned, otherwise the S3 objects it
+// references are queued up to be read.
+func (q *Queue) parse(m sqstypes.Message) (Record, bool, error) {
+	body := []byte(aws.ToString(m.Body))
+
+	if rec, ok := unwrapEventBridge(body); ok {
+		return rec, true, nil
+	}
+
+	var notification s3Notification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return Record{}, false, fmt.Errorf("queue: message %s: %w", aws.ToString(m.MessageId), err)
+	}
+
+	// The test event is sent when notifications are first configured, and a shared EventBridge rule can send events
+	// for things other than CloudTrail. Neither has anything for us.
+	if notification.Event == "s3:TestEvent" || notification.DetailType != nil {
+		return Record{}, false, nil
+	}
+
+	if len(notification.Records) == 0 {
+		return Record{}, false, fmt.Errorf("queue: message %s: skipping, not an S3 notification or CloudTrail event", aws.ToString(m.MessageId))
+	}
+
+	var objects []s3Object
+	for _, r := range notification.Records {
+		if r.EventSource != "aws:s3" {
+			continue
+		}
+
+		// Keys are URL encoded in event notifications.
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			return Record{}, false, fmt.Errorf("queue: message %s: decoding key %s: %w", aws.ToString(m.MessageId), r.S3.Object.Key, err)
+		}
+
+		// Skip digest files and anything else that isn't a log file.
+		if file, ok := parseLogPath(key); !ok || file.Date.IsZero() || !isLogFile(key) {
+			continue
+		}
+
+		objects = append(objects, s3Object{Bucket: r.S3.Bucket.Name, Key: key})
+	}
+	q.objects = append(q.objects, objects...)
+	return Record{}, false, nil
+}
+
+func (q *Queue) receive() error {
+	q.extending.Do(func() { go q.extend() })
+
+	resp, err := q.sqs.ReceiveMessage(q.ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: 10,
+		WaitTimeSeconds:     20,
+		VisibilityTimeout:   int32(q.visibility / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("queue: receive: %w", err)
+	}
+
+	q.mu.Lock()
+	for _, m := range resp.Messages {
+		q.inflight[aws.ToString(m.ReceiptHandle)] = true
+	}
+	q.mu.Unlock()
+
+	q.messages = resp.Messages
+	return nil
+}
+
+// extend keeps the messages that haven't been deleted yet hidden from other consumers until the queue is closed.
+func (q *Queue) extend() {
+	ticker := time.NewTicker(q.visibility / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		var handles []string
+		for handle := range q.inflight {
+			handles = append(handles, handle)
+		}
+		q.mu.Unlock()
+
+		for _, handle := range handles {
+			_, err := q.sqs.ChangeMessageVisibility(q.ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(q.url),
+				ReceiptHandle:     aws.String(handle),
+				VisibilityTimeout: int32(q.visibility / time.Second),
+			})
+			if err != nil && q.ctx.Err() == nil {
+				q.error(fmt.Errorf("queue: extend visibility timeout: %w", err))
+			}
+		}
+	}
+}
+
+// error reports err with OnError if it's set.
+func (q *Queue) error(err error) {
+	if q.OnError != nil {
+		q.OnError(err)
+	}
+}
+
+func (q *Queue) open(obj s3Object) error {
+	resp, err := q.s3.GetObject(q.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		return fmt.Errorf("queue: get s3://%s/%s: %w", obj.Bucket, obj.Key, err)
+	}
+
+	q.cur, err = NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return fmt.Errorf("queue: s3://%s/%s: %w", obj.Bucket, obj.Key, err)
+	}
+	q.body = resp.Body
+	return nil
+}
+
+func (q *Queue) Close() error {
+	q.cancel()
+	return nil
+}
+
+// message tracks the events read from an SQS message which haven't been acknowledged yet.
+type message struct {
+	q      *Queue
+	handle *string
+
+	mu      sync.Mutex
+	pending int
+	reading bool
+}
+
+// hold records that another event was read from the message, the returned function acknowledges it.
+func (m *message) hold() func() error {
+	m.mu.Lock()
+	m.pending++
+	m.mu.Unlock()
+
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() {
+			m.mu.Lock()
+			m.pending--
+			done := m.pending == 0 && !m.reading
+			m.mu.Unlock()
+
+			if done {
+				err = m.delete()
+			}
+		})
+		return err
+	}
+}
+
+// finish is called once every event has been read from the message.
+func (m *message) finish() error {
+	m.mu.Lock()
+	m.reading = false
+	done := m.pending == 0
+	m.mu.Unlock()
+
+	if done {
+		return m.delete()
+	}
+	return nil
+}
+
+// release stops extending the visibility timeout of a message we're not going to delete.
+func (m *message) release() {
+	m.q.mu.Lock()
+	delete(m.q.inflight, aws.ToString(m.handle))
+	m.q.mu.Unlock()
+}
+
+func (m *message) delete() error {
+	m.release()
+
+	// Use a fresh context, we still want to clean up messages we've finished processing while shutting down.
+	_, err := m.q.sqs.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(m.q.url),
+		ReceiptHandle: m.handle,
+	})
+	if err != nil {
+		return fmt.Errorf("queue: delete message: %w", err)
+	}
+	return nil
+}
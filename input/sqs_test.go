@@ -0,0 +1,174 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSQS struct {
+	messages []sqstypes.Message
+	deleted  []string
+
+	mu       sync.Mutex
+	extended []string
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	if len(f.messages) == 0 {
+		// Long poll until the test closes the queue.
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	resp := &sqs.ReceiveMessageOutput{Messages: f.messages}
+	f.messages = nil
+	return resp, nil
+}
+
+func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.extended = append(f.extended, aws.ToString(params.ReceiptHandle))
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+type fakeS3 map[string]string
+
+func (f fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	path := aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key)
+	data, ok := f[path]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", path)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewBufferString(data))}, nil
+}
+
+func sqsMessage(handle, body string) sqstypes.Message {
+	return sqstypes.Message{MessageId: aws.String(handle), ReceiptHandle: aws.String(handle), Body: aws.String(body)}
+}
+
+func TestQueue(t *testing.T) {
+	logKey := "AWSLogs/111111111111/CloudTrail/us-east-1/2022/10/01/111111111111_CloudTrail_us-east-1_20221001T0005Z_a.json.gz"
+	digestKey := "AWSLogs/111111111111/CloudTrail-Digest/us-east-1/2022/10/01/111111111111_CloudTrail-Digest_us-east-1_trail_us-east-1_20221001T010000Z.json.gz"
+
+	fakeSqs := &fakeSQS{
+		messages: []sqstypes.Message{
+			sqsMessage("test", `{"Service": "Amazon S3", "Event": "s3:TestEvent"}`),
+			sqsMessage("other", `{"version": "0", "detail-type": "EC2 Instance State-change Notification", "source": "aws.ec2", "detail": {}}`),
+			sqsMessage("invalid", `not json`),
+			sqsMessage("s3", fmt.Sprintf(`{"Records": [
+				{"eventSource": "aws:s3", "s3": {"bucket": {"name": "trail"}, "object": {"key": "%s"}}},
+				{"eventSource": "aws:s3", "s3": {"bucket": {"name": "trail"}, "object": {"key": "%s"}}}
+			]}`, logKey, digestKey)),
+			sqsMessage("eventbridge", `{
+				"version": "0",
+				"id": "11111111-1111-1111-1111-111111111111",
+				"detail-type": "AWS API Call via CloudTrail",
+				"source": "aws.sts",
+				"detail": `+event("3", 3)+`
+			}`),
+		},
+	}
+	fakeS3 := fakeS3{
+		"trail/" + logKey: gz(t, `{"Records": [`+event("1", 1)+`,`+event("2", 2)+`]}`),
+	}
+
+	q := NewQueue(context.Background(), fakeSqs, fakeS3, "https://queue")
+
+	var skipped int
+	q.OnError = func(err error) {
+		skipped++
+	}
+
+	var ids []string
+	var deleted [][]string
+	for i := 0; i < 3; i++ {
+		rec, err := q.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		ids = append(ids, eventIds(t, &events{string(rec.Event)})...)
+
+		if err := Ack(rec); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+		deleted = append(deleted, append([]string{}, fakeSqs.deleted...))
+	}
+
+	if diff := cmp.Diff([]string{"1", "2", "3"}, ids); diff != "" {
+		t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+	}
+
+	// The S3 message is only deleted once both of its events have been acknowledged, and the invalid one is left
+	// to be delivered again.
+	wantDeleted := [][]string{
+		{"test", "other"},
+		{"test", "other"},
+		{"test", "other", "s3", "eventbridge"},
+	}
+	if diff := cmp.Diff(wantDeleted, deleted); diff != "" {
+		t.Errorf("DeleteMessage() mismatch (-want +got):\n%s", diff)
+	}
+	if skipped != 1 {
+		t.Errorf("OnError() called %d times, want 1", skipped)
+	}
+
+	q.Close()
+	if _, err := q.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want io.EOF", err)
+	}
+}
+
+// Messages with events that haven't been acknowledged yet are kept hidden until they are.
+func TestQueue_Extend(t *testing.T) {
+	fakeSqs := &fakeSQS{
+		messages: []sqstypes.Message{
+			sqsMessage("eventbridge", `{"detail-type": "AWS API Call via CloudTrail", "detail": `+event("1", 1)+`}`),
+		},
+	}
+	q := NewQueue(context.Background(), fakeSqs, fakeS3{}, "https://queue")
+	q.visibility = 20 * time.Millisecond
+	defer q.Close()
+
+	rec, err := q.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	extended := func() int {
+		fakeSqs.mu.Lock()
+		defer fakeSqs.mu.Unlock()
+		return len(fakeSqs.extended)
+	}
+	for deadline := time.Now().Add(time.Second); extended() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("ChangeMessageVisibility() wasn't called for a pending message")
+		}
+	}
+
+	if err := Ack(rec); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	// Let an extension that was already under way finish.
+	time.Sleep(q.visibility)
+	n := extended()
+	time.Sleep(5 * q.visibility)
+	if got := extended(); got != n {
+		t.Errorf("ChangeMessageVisibility() called %d more times after the message was deleted", got-n)
+	}
+}
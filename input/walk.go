@@ -0,0 +1,287 @@
+package input
+
+import (
+	"fmt"
+	"github.com/ryanjarv/goutil/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogFile is a CloudTrail log file found under the standard AWSLogs/<account>/CloudTrail/<region>/YYYY/MM/DD layout.
+type LogFile struct {
+	Path    string
+	Account string
+	Region  string
+
+	// Date is the day the file was delivered, taken from the path.
+	Date time.Time
+}
+
+// Filter limits which log files are read based on the information in their path.
+//
+// Empty fields match everything.
+type Filter struct {
+	Accounts []string
+	Regions  []string
+
+	// Start and End are inclusive and only compared at day granularity, this is all we can get from the path.
+	Start time.Time
+	End   time.Time
+}
+
+func (f Filter) matchAccount(account string) bool {
+	return len(f.Accounts) == 0 || utils.In(f.Accounts, account)
+}
+
+func (f Filter) matchRegion(region string) bool {
+	return len(f.Regions) == 0 || utils.In(f.Regions, region)
+}
+
+func (f Filter) matchDate(date time.Time) bool {
+	if !f.Start.IsZero() && date.Before(truncateDay(f.Start)) {
+		return false
+	}
+	if !f.End.IsZero() && date.After(truncateDay(f.End)) {
+		return false
+	}
+	return true
+}
+
+// matchDates reports whether any day from first to last matches.
+func (f Filter) matchDates(first, last time.Time) bool {
+	if !f.Start.IsZero() && last.Before(truncateDay(f.Start)) {
+		return false
+	}
+	if !f.End.IsZero() && first.After(truncateDay(f.End)) {
+		return false
+	}
+	return true
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Walk returns every log file under root matching filter.
+//
+// Both the single account (AWSLogs/<account>/CloudTrail/...) and organization trail
+// (AWSLogs/<org-id>/<account>/CloudTrail/...) layouts are supported. Digest and Insights files are skipped. Files are
+// returned ordered by account, region, then path, which within a region is the order they were delivered in.
+func Walk(root string, filter Filter) ([]LogFile, error) {
+	return walk(root, filter, nil)
+}
+
+// walk is Walk, skipping days before since[dir] in each region directory dir. Directories are relative to root, as
+// returned by regionDir.
+func walk(root string, filter Filter, since map[string]time.Time) ([]LogFile, error) {
+	var files []LogFile
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		file, ok := parseLogPath(rel)
+
+		if d.IsDir() {
+			// Prune directories we know won't match as early as possible, there may be years of logs under here.
+			if ok && (!filter.matchAccount(file.Account) || (file.Region != "" && !filter.matchRegion(file.Region))) {
+				return fs.SkipDir
+			}
+			if dir, first, last, ok := dirDates(rel); ok && (!filter.matchDates(first, last) || last.Before(since[dir])) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !ok || file.Date.IsZero() || !isLogFile(path) {
+			return nil
+		}
+		if !filter.matchAccount(file.Account) || !filter.matchRegion(file.Region) || !filter.matchDate(file.Date) {
+			return nil
+		}
+		if file.Date.Before(since[regionDir(rel)]) {
+			return nil
+		}
+
+		file.Path = path
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk: %s: %w", root, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Path < b.Path
+	})
+	return files, nil
+}
+
+func isLogFile(path string) bool {
+	return strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".json.gz")
+}
+
+// parseLogPath pulls what it can out of a path relative to the walk root, ok is false if the path isn't under a
+// CloudTrail directory. Account and Region are filled in as soon as they're available so directories can be pruned.
+func parseLogPath(rel string) (file LogFile, ok bool) {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	for i, part := range parts {
+		// CloudTrail-Digest and CloudTrail-Insight won't match here.
+		if part != "CloudTrail" || i == 0 {
+			continue
+		}
+
+		file.Account = parts[i-1]
+		rest := parts[i+1:]
+
+		if len(rest) > 0 {
+			file.Region = rest[0]
+		}
+		if len(rest) > 4 {
+			date, err := time.Parse("2006/01/02", strings.Join(rest[1:4], "/"))
+			if err != nil {
+				return file, false
+			}
+			file.Date = date
+		}
+		return file, true
+	}
+	return file, false
+}
+
+// regionDir returns the region directory rel is in, rel is a log file path relative to the walk root.
+func regionDir(rel string) string {
+	return filepath.ToSlash(filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(rel)))))
+}
+
+// dirDates returns the region directory and the first and last day a year, month or day directory can have logs
+// for, ok is false for anything else.
+func dirDates(rel string) (dir string, first, last time.Time, ok bool) {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	for i, part := range parts {
+		if part != "CloudTrail" || i == 0 {
+			continue
+		}
+
+		if len(parts) < i+3 {
+			return "", first, last, false
+		}
+		dates := parts[i+2:]
+		layout := []string{"2006", "2006/01", "2006/01/02"}
+		if len(dates) > len(layout) {
+			return "", first, last, false
+		}
+
+		first, err := time.Parse(layout[len(dates)-1], strings.Join(dates, "/"))
+		if err != nil {
+			return "", first, last, false
+		}
+		switch len(dates) {
+		case 1:
+			last = first.AddDate(1, 0, -1)
+		case 2:
+			last = first.AddDate(0, 1, -1)
+		default:
+			last = first
+		}
+		return strings.Join(parts[:i+2], "/"), first, last, true
+	}
+	return "", first, last, false
+}
+
+// OpenDirectory returns a Source for every log file under root matching opts.Filter.
+//
+// Each account and region is read as its own stream, the streams are then merged on eventTime.
+func OpenDirectory(root string, opts Options) (Source, error) {
+	files, err := Walk(root, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return openFiles(files, opts.Format), nil
+}
+
+// openFiles reads files as one stream per account and region, as returned by Walk.
+func openFiles(files []LogFile, format string) Source {
+	var sources []Source
+	for i := 0; i < len(files); {
+		j := i
+		for j < len(files) && files[j].Account == files[i].Account && files[j].Region == files[i].Region {
+			j++
+		}
+		sources = append(sources, &chain{files: files[i:j], format: format})
+		i = j
+	}
+	return Merge(sources...)
+}
+
+// chain reads a list of log files one after another, only one file is open at a time.
+type chain struct {
+	files  []LogFile
+	format string
+	cur    Source
+	f      *os.File
+}
+
+func (c *chain) Next() (Record, error) {
+	for {
+		if c.cur == nil {
+			if len(c.files) == 0 {
+				return Record{}, io.EOF
+			}
+			if err := c.open(c.files[0].Path); err != nil {
+				return Record{}, err
+			}
+			c.files = c.files[1:]
+		}
+
+		rec, err := c.cur.Next()
+		if err == io.EOF {
+			c.close()
+			continue
+		} else if err != nil {
+			name := c.f.Name()
+			c.close()
+			return Record{}, fmt.Errorf("%s: %w", name, err)
+		}
+		return rec, nil
+	}
+}
+
+func (c *chain) open(path string) (err error) {
+	c.f, err = os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	c.cur, err = NewFormatReader(c.f, c.format)
+	if err != nil {
+		c.f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *chain) close() {
+	c.f.Close()
+	c.cur = nil
+}
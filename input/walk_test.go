@@ -0,0 +1,149 @@
+package input
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testLogs = "testdata/AWSLogs"
+
+func eventIds(t *testing.T, src Source) (ids []string) {
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			return ids
+		} else if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+
+		var key eventKey
+		if err := json.Unmarshal(rec.Event, &key); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, key.EventID)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2022, time.October, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		filter Filter
+		since  map[string]time.Time
+		want   []LogFile
+	}{
+		{
+			name:   "all",
+			filter: Filter{},
+			want: []LogFile{
+				{Account: "111111111111", Region: "eu-west-1", Date: day(1)},
+				{Account: "111111111111", Region: "eu-west-1", Date: day(2)},
+				{Account: "111111111111", Region: "us-east-1", Date: day(1)},
+				{Account: "111111111111", Region: "us-east-1", Date: day(2)},
+				{Account: "222222222222", Region: "us-east-1", Date: day(1)},
+			},
+		},
+		{
+			name:   "account",
+			filter: Filter{Accounts: []string{"222222222222"}},
+			want: []LogFile{
+				{Account: "222222222222", Region: "us-east-1", Date: day(1)},
+			},
+		},
+		{
+			name:   "region",
+			filter: Filter{Regions: []string{"eu-west-1"}},
+			want: []LogFile{
+				{Account: "111111111111", Region: "eu-west-1", Date: day(1)},
+				{Account: "111111111111", Region: "eu-west-1", Date: day(2)},
+			},
+		},
+		{
+			name:   "start",
+			filter: Filter{Start: day(2).Add(time.Hour)},
+			want: []LogFile{
+				{Account: "111111111111", Region: "eu-west-1", Date: day(2)},
+				{Account: "111111111111", Region: "us-east-1", Date: day(2)},
+			},
+		},
+		{
+			name:   "end",
+			filter: Filter{Accounts: []string{"111111111111"}, Regions: []string{"us-east-1"}, End: day(1)},
+			want: []LogFile{
+				{Account: "111111111111", Region: "us-east-1", Date: day(1)},
+			},
+		},
+		{
+			name:  "since",
+			since: map[string]time.Time{"111111111111/CloudTrail/us-east-1": day(2)},
+			want: []LogFile{
+				{Account: "111111111111", Region: "eu-west-1", Date: day(1)},
+				{Account: "111111111111", Region: "eu-west-1", Date: day(2)},
+				{Account: "111111111111", Region: "us-east-1", Date: day(2)},
+				{Account: "222222222222", Region: "us-east-1", Date: day(1)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walk(testLogs, tt.filter, tt.since)
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+
+			for i, file := range got {
+				if !isLogFile(file.Path) || filepath.Base(filepath.Dir(file.Path)) != file.Date.Format("02") {
+					t.Errorf("Walk() unexpected path %s", file.Path)
+				}
+				got[i].Path = ""
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Walk() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOpenDirectory(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{
+			name: "multi_account_multi_region",
+			want: []string{"1", "2", "3", "4", "5", "6", "7", "8"},
+		},
+		{
+			name:   "single_account_multi_region",
+			filter: Filter{Accounts: []string{"111111111111"}},
+			want:   []string{"1", "2", "4", "5", "7", "8"},
+		},
+		{
+			name:   "multi_account_single_region",
+			filter: Filter{Regions: []string{"us-east-1"}},
+			want:   []string{"1", "3", "4", "6", "7"},
+		},
+		{
+			name:   "no_match",
+			filter: Filter{Accounts: []string{"333333333333"}},
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := OpenDirectory(testLogs, Options{Filter: tt.filter})
+			if err != nil {
+				t.Fatalf("OpenDirectory() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, eventIds(t, src)); diff != "" {
+				t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"container/heap"
+	"github.com/ryanjarv/sqs/types"
+	"time"
+)
+
+// Defaults for the reorder window.
+const (
+	DefaultWindow = time.Minute
+	DefaultSize   = 10000
+)
+
+// Held is an event waiting in a Reorder buffer along with whatever the caller needs to write it out later.
+type Held struct {
+	Event types.AssumeRoleEvent
+	Value interface{}
+
+	seq   int
+	added time.Time
+}
+
+// Reorder holds events for a short window so that events delivered out of order are released in eventTime order.
+//
+// An event is released once an event more than window newer has been pushed, once it has been held for longer than
+// window, or when more than size events are held. A window of zero disables reordering.
+type Reorder struct {
+	window time.Duration
+	size   int
+
+	// now is used for how long events have been held, it's here for testing.
+	now func() time.Time
+
+	held   heldHeap
+	latest time.Time
+	seq    int
+}
+
+func NewReorder(window time.Duration, size int) *Reorder {
+	return &Reorder{window: window, size: size, now: time.Now}
+}
+
+// Push adds event to the buffer.
+func (r *Reorder) Push(event types.AssumeRoleEvent, value interface{}) {
+	if event.EventTime.After(r.latest) {
+		r.latest = event.EventTime
+	}
+
+	r.seq++
+	heap.Push(&r.held, Held{Event: event, Value: value, seq: r.seq, added: r.now()})
+}
+
+// Ready removes and returns the events that no longer need to be held, in eventTime order.
+func (r *Reorder) Ready() (ready []Held) {
+	now := r.now()
+	for len(r.held) > 0 {
+		next := r.held[0]
+		if r.window > 0 && len(r.held) <= r.size &&
+			r.latest.Sub(next.Event.EventTime) <= r.window &&
+			now.Sub(next.added) <= r.window {
+			break
+		}
+		ready = append(ready, heap.Pop(&r.held).(Held))
+	}
+	return ready
+}
+
+// Flush removes and returns every held event in eventTime order.
+func (r *Reorder) Flush() (ready []Held) {
+	for len(r.held) > 0 {
+		ready = append(ready, heap.Pop(&r.held).(Held))
+	}
+	return ready
+}
+
+// Len returns the number of events currently held.
+func (r *Reorder) Len() int {
+	return len(r.held)
+}
+
+type heldHeap []Held
+
+func (h heldHeap) Len() int { return len(h) }
+func (h heldHeap) Less(i, j int) bool {
+	if !h[i].Event.EventTime.Equal(h[j].Event.EventTime) {
+		return h[i].Event.EventTime.Before(h[j].Event.EventTime)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h heldHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *heldHeap) Push(x interface{}) { *h = append(*h, x.(Held)) }
+func (h *heldHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
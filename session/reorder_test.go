@@ -0,0 +1,103 @@
+package session
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+func heldIds(held []Held) (ids []string) {
+	for _, h := range held {
+		ids = append(ids, h.Event.EventID)
+	}
+	return ids
+}
+
+func TestReorder(t *testing.T) {
+	type push struct {
+		id     string
+		second int
+	}
+	tests := []struct {
+		name      string
+		window    time.Duration
+		size      int
+		pushes    []push
+		wantReady []string
+		wantFlush []string
+	}{
+		{
+			name:      "held within window",
+			window:    time.Minute,
+			size:      DefaultSize,
+			pushes:    []push{{"2", 2}, {"1", 1}, {"3", 3}},
+			wantFlush: []string{"1", "2", "3"},
+		},
+		{
+			name:      "released after window",
+			window:    10 * time.Second,
+			size:      DefaultSize,
+			pushes:    []push{{"2", 2}, {"1", 1}, {"3", 12}, {"4", 13}},
+			wantReady: []string{"1", "2"},
+			wantFlush: []string{"3", "4"},
+		},
+		{
+			name:      "released over size",
+			window:    time.Minute,
+			size:      2,
+			pushes:    []push{{"3", 3}, {"2", 2}, {"1", 1}},
+			wantReady: []string{"1"},
+			wantFlush: []string{"2", "3"},
+		},
+		{
+			name:      "stable for the same eventTime",
+			window:    time.Minute,
+			size:      DefaultSize,
+			pushes:    []push{{"b", 1}, {"a", 1}, {"c", 1}},
+			wantFlush: []string{"b", "a", "c"},
+		},
+		{
+			name:      "disabled",
+			window:    0,
+			size:      DefaultSize,
+			pushes:    []push{{"2", 2}, {"1", 1}},
+			wantReady: []string{"2", "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReorder(tt.window, tt.size)
+			r.now = func() time.Time { return testDate }
+
+			var ready []Held
+			for _, p := range tt.pushes {
+				r.Push(use(p.id, "ASIA1111111111111111", 0, p.second), nil)
+				ready = append(ready, r.Ready()...)
+			}
+
+			if diff := cmp.Diff(tt.wantReady, heldIds(ready)); diff != "" {
+				t.Errorf("Ready() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantFlush, heldIds(r.Flush())); diff != "" {
+				t.Errorf("Flush() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReorder_HeldTooLong(t *testing.T) {
+	now := testDate
+	r := NewReorder(time.Minute, DefaultSize)
+	r.now = func() time.Time { return now }
+
+	r.Push(use("1", "ASIA1111111111111111", 0, 1), nil)
+	if got := r.Ready(); len(got) != 0 {
+		t.Fatalf("Ready() = %v, want nothing", heldIds(got))
+	}
+
+	// Nothing newer has come in, the event should still be released once it has been waiting for the window.
+	now = now.Add(2 * time.Minute)
+	if diff := cmp.Diff([]string{"1"}, heldIds(r.Ready())); diff != "" {
+		t.Errorf("Ready() mismatch (-want +got):\n%s", diff)
+	}
+}
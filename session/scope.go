@@ -0,0 +1,55 @@
+package session
+
+import (
+	"github.com/ryanjarv/sqs/types"
+)
+
+// Scope is an identity and every session derived from it, it's used to limit output to what one principal did.
+//
+// The identity can be an ARN, access key ID or session key. Sessions are added to the scope as events made with them
+// are seen, so sessions created after the scope are included as well.
+type Scope struct {
+	identity string
+
+	// keys are the sessions known to be derived from identity.
+	keys map[string]bool
+}
+
+func NewScope(identity string) *Scope {
+	return &Scope{identity: identity, keys: map[string]bool{}}
+}
+
+// Contains reports whether an event made by i is in the scope, hops is the lineage of the session it was made with
+// or nil if the session isn't known.
+func (s *Scope) Contains(i types.UserIdentity, hops []Hop) bool {
+	if s.matches(i) {
+		return true
+	}
+
+	for n, hop := range hops {
+		if s.keys[hop.SessionKey] || hop.SessionKey == s.identity || s.matches(hop.Original) {
+			// Remember the sessions between the event and the identity, in case the lineage is cut short later on.
+			for _, h := range hops[:n+1] {
+				s.keys[h.SessionKey] = true
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsKey reports whether the session with key is known to be in the scope.
+func (s *Scope) ContainsKey(key string) bool {
+	return key == s.identity || s.keys[key]
+}
+
+// matches reports whether i is the identity the scope was created for.
+func (s *Scope) matches(i types.UserIdentity) bool {
+	switch {
+	case i.Arn != "" && i.Arn == s.identity:
+		return true
+	case i.AccessKeyId != "" && i.AccessKeyId == s.identity:
+		return true
+	}
+	return s.ContainsKey(i.Id())
+}
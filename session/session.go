@@ -0,0 +1,149 @@
+package session
+
+import (
+	"github.com/ryanjarv/sqs/types"
+	"time"
+)
+
+// Confidence is how sure we are that a session was attributed to the right identity.
+type Confidence string
+
+const (
+	// High is used when the event that created the session was seen with the identity that made it.
+	High Confidence = "high"
+
+	// Medium is used when more than one session matched and one was picked based on the sourceIPAddress and
+	// userAgent of the event.
+	Medium Confidence = "medium"
+
+	// Low is used when the session could belong to someone else, for example when only the target account's copy
+	// of a cross-account call was seen.
+	Low Confidence = "low"
+)
+
+var confidenceRank = map[Confidence]int{Low: 0, Medium: 1, High: 2}
+
+// lowest returns the lowest of a and b.
+func lowest(a, b Confidence) Confidence {
+	if confidenceRank[b] < confidenceRank[a] {
+		return b
+	}
+	return a
+}
+
+// Session is a set of credentials created by an event.
+type Session struct {
+	// Key is the UserIdentity.Id() of events made with the session.
+	Key string `json:"key"`
+
+	// Original is the identity that created the session.
+	Original types.UserIdentity `json:"original"`
+
+	// Target is the identity of events made with the session.
+	Target types.UserIdentity `json:"target"`
+
+	// EventID is the event that created the session.
+	EventID string `json:"eventID"`
+
+	// EventTime is when the session was created.
+	EventTime time.Time `json:"eventTime"`
+
+	// EventName is the API call that created the session.
+	EventName string `json:"eventName"`
+
+	// Parent is the key of the session that created this one, it's empty if the session was created by a long-term
+	// identity.
+	Parent string `json:"parent,omitempty"`
+
+	// SharedEventID is set when the session was created by a call logged in more than one account.
+	SharedEventID string `json:"sharedEventID,omitempty"`
+
+	Confidence Confidence `json:"confidence"`
+
+	// Federation is the external identity used to create the session with AssumeRoleWithSAML or
+	// AssumeRoleWithWebIdentity.
+	Federation *types.Federation `json:"federation,omitempty"`
+
+	// Workload is the EC2 instance, Lambda function, ECS task or CodeBuild build the session was created for by an
+	// AWS service.
+	Workload *types.Workload `json:"workload,omitempty"`
+
+	// SourceIPAddress and UserAgent are from the event that created the session, they're used to tell sessions
+	// apart when their keys collide.
+	SourceIPAddress string `json:"sourceIPAddress,omitempty"`
+	UserAgent       string `json:"userAgent,omitempty"`
+
+	// Expiration is when the session's credentials expire, it's nil if we don't know.
+	Expiration *time.Time `json:"expiration,omitempty"`
+
+	// token is the session token returned by the creating event, it's only used to spot duplicates.
+	token string
+}
+
+// Expired reports whether the session had expired by t.
+func (s Session) Expired(t time.Time) bool {
+	return s.Expiration != nil && t.After(*s.Expiration)
+}
+
+// Active reports whether the session could have been used at t, sessions without an expiration are active from
+// when they were created.
+func (s Session) Active(t time.Time) bool {
+	return !s.EventTime.After(t) && !s.Expired(t)
+}
+
+// newSession returns the session created by event, target is event.Target() and key is its key.
+func newSession(key string, target types.UserIdentity, event types.AssumeRoleEvent) Session {
+	s := Session{
+		Key:             key,
+		Original:        event.Source(),
+		Target:          target,
+		EventID:         event.EventID,
+		EventTime:       event.EventTime,
+		EventName:       event.EventName,
+		SharedEventID:   event.SharedEventID,
+		Confidence:      High,
+		SourceIPAddress: event.SourceIPAddress,
+		UserAgent:       event.UserAgent,
+		Federation:      event.Federation(),
+		Workload:        event.Workload(),
+		token:           sessionToken(event),
+	}
+	if exp, ok := event.Expiration(); ok {
+		s.Expiration = &exp
+	}
+
+	// The target account's copy of a cross-account call only identifies the caller by account and principal ID,
+	// which may be shared by many sessions in the source account.
+	if event.CrossAccount() {
+		s.Confidence = Low
+	}
+	return s
+}
+
+// Hop is one session in the lineage of an event.
+type Hop struct {
+	// SessionKey is the key of the session.
+	SessionKey string `json:"sessionKey"`
+
+	// EventID, EventTime and EventName are the event that created the session.
+	EventID   string    `json:"eventID"`
+	EventTime time.Time `json:"eventTime"`
+	EventName string    `json:"eventName"`
+
+	// Original is the identity that created the session, for the last hop this is the long-term identity everything
+	// else came from.
+	Original types.UserIdentity `json:"original"`
+
+	Confidence Confidence `json:"confidence"`
+}
+
+func newHop(s Session) Hop {
+	return Hop{
+		SessionKey: s.Key,
+		EventID:    s.EventID,
+		EventTime:  s.EventTime,
+		EventName:  s.EventName,
+		Original:   s.Original,
+		Confidence: s.Confidence,
+	}
+}
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// StateVersion is the version of the state written by Snapshot. It's bumped whenever the format changes, Restore
+// refuses state written with a different version rather than silently dropping what it doesn't understand.
+const StateVersion = 1
+
+// StateVersionError is returned by Restore when the state was written with a different StateVersion.
+type StateVersionError struct {
+	Version int
+}
+
+func (e StateVersionError) Error() string {
+	return fmt.Sprintf("unsupported state version %d, expected %d", e.Version, StateVersion)
+}
+
+// state is everything a Tracker knows, in the format written by Snapshot.
+type state struct {
+	Version        int                       `json:"version"`
+	Latest         time.Time                 `json:"latest"`
+	Sessions       map[string][]savedSession `json:"sessions"`
+	Collisions     int                       `json:"collisions"`
+	Shared         map[string]string         `json:"shared"`
+	Console        map[string][]string       `json:"console"`
+	IdentityCenter map[string]string         `json:"identityCenter"`
+	Orphans        []savedOrphan             `json:"orphans"`
+}
+
+// savedSession adds the fields of Session that aren't normally output.
+type savedSession struct {
+	Session
+	Token string `json:"token,omitempty"`
+}
+
+type savedOrphan struct {
+	Key     string `json:"key"`
+	EventID string `json:"eventID"`
+}
+
+// Snapshot writes the sessions and pending orphans of the tracker to w so they can be restored with Restore.
+func (t *Tracker) Snapshot(w io.Writer) error {
+	s := state{
+		Version:        StateVersion,
+		Latest:         t.latest,
+		Sessions:       make(map[string][]savedSession, len(t.sessions)),
+		Collisions:     t.collisions,
+		Shared:         t.shared,
+		Console:        t.console,
+		IdentityCenter: t.identityCenter,
+	}
+	for key, sessions := range t.sessions {
+		for _, sess := range sessions {
+			s.Sessions[key] = append(s.Sessions[key], savedSession{Session: sess, Token: sess.token})
+		}
+	}
+	for _, o := range t.orphanList {
+		// Orphans are left in the list after they're resolved, only save the ones still pending.
+		for _, pending := range t.orphans[o.key] {
+			if pending == o {
+				s.Orphans = append(s.Orphans, savedOrphan{Key: o.key, EventID: o.eventID})
+				break
+			}
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the sessions and orphans of the tracker with ones saved by Snapshot. A StateVersionError is
+// returned if the state was written with a different StateVersion, the tracker is left unchanged when an error is
+// returned.
+func (t *Tracker) Restore(r io.Reader) error {
+	var s state
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	if s.Version != StateVersion {
+		return fmt.Errorf("restore: %w", StateVersionError{Version: s.Version})
+	}
+
+	restored := NewTracker(t.maxOrphans)
+	restored.OnCreate = t.OnCreate
+	restored.latest = s.Latest
+	restored.collisions = s.Collisions
+	for key, sessions := range s.Sessions {
+		for _, saved := range sessions {
+			sess := saved.Session
+			sess.token = saved.Token
+			restored.sessions[key] = append(restored.sessions[key], sess)
+			restored.sessionTokens[sess.token] = true
+		}
+	}
+	for k, v := range s.Shared {
+		restored.shared[k] = v
+	}
+	for k, v := range s.Console {
+		restored.console[k] = v
+	}
+	for k, v := range s.IdentityCenter {
+		restored.identityCenter[k] = v
+	}
+	for _, o := range s.Orphans {
+		restored.addOrphan(orphan{key: o.Key, eventID: o.EventID})
+	}
+
+	*t = *restored
+	return nil
+}
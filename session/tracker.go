@@ -0,0 +1,562 @@
+package session
+
+import (
+	"fmt"
+	"github.com/ryanjarv/sqs/types"
+	"time"
+)
+
+// DefaultOrphans is the default number of orphaned events remembered by a Tracker.
+const DefaultOrphans = 10000
+
+// DefaultGrace is the default time sessions are kept after they expire, see Tracker.Evict.
+const DefaultGrace = time.Hour
+
+// maxLifetime is the longest a session can last, GetSessionToken sessions can be up to 36 hours.
+const maxLifetime = 36 * time.Hour
+
+// DefaultLineage is the default number of hops returned by Tracker.Lineage.
+const DefaultLineage = 32
+
+// Tracker follows the sessions created by events, mapping the key of each new session to the identity that
+// created it.
+//
+// Events can be delivered before the event that created the session they were made with. When that happens the
+// event is remembered as an orphan and a Correction is returned once the creating event shows up.
+//
+// Cross-account calls are logged in both accounts, the copies are paired on their sharedEventID and the source
+// account's copy is preferred since it has the full identity of the caller.
+//
+// Access keys are occasionally recycled and session tokens aren't logged, so two sessions can end up with the same
+// key. Both are kept when this happens and events made with the key are attributed to whichever session has the
+// closest sourceIPAddress and userAgent, with a lower confidence. Sessions that had expired by the time of the event
+// are ruled out first.
+//
+// Sessions are kept until they are evicted with Evict, some time after they expire.
+type Tracker struct {
+	// OnCreate is called with each session as it's tracked, including when the source account's copy of a
+	// cross-account call replaces the target account's.
+	OnCreate func(Session)
+
+	// sessions maps each session key to the sessions it could refer to, there is more than one if keys collided.
+	sessions      map[string][]Session
+	sessionTokens map[string]bool
+	collisions    int
+
+	// shared maps the sharedEventID of cross-account calls to the key of the session they created.
+	shared map[string]string
+
+	// console maps the ARN of console sessions to their keys, SwitchRole only tells us the ARN of the caller.
+	console map[string][]string
+
+	// identityCenter maps Identity Center user names to their user IDs.
+	identityCenter map[string]string
+
+	// unhandled counts the identities we couldn't key properly by what was wrong with them.
+	unhandled map[string]int
+
+	// latest is the time of the most recent event observed, sessions are evicted relative to it rather than the wall
+	// clock so old logs can be replayed.
+	latest time.Time
+
+	// orphans are events made with sessions we don't know about yet, keyed on the session key.
+	orphans    map[string][]orphan
+	orphanList []orphan
+	maxOrphans int
+}
+
+type orphan struct {
+	key     string
+	eventID string
+}
+
+// Correction amends an event that was written before the event that created its session was seen.
+type Correction struct {
+	EventID              string `json:"eventID"`
+	SessionKey           string `json:"sessionKey"`
+	OriginalUserIdentity types.UserIdentity
+	Confidence           Confidence `json:"confidence"`
+}
+
+// Attribution is the session an event was made with.
+type Attribution struct {
+	Session Session
+
+	// Confidence is how sure we are the event was made with Session, it's never higher than the confidence of the
+	// session itself.
+	Confidence Confidence
+
+	// Candidates are all the sessions the event could have been made with when session keys collided.
+	Candidates []Session
+
+	// Expired is set if the event was made after Session expired, which shouldn't be possible.
+	Expired bool
+}
+
+// CollisionError is returned when a new session has the same key as one we've already seen. The new session is
+// still tracked, this is only informational.
+type CollisionError struct {
+	Key string
+
+	// EventIDs are the events that created each of the sessions with this key.
+	EventIDs []string
+}
+
+func (e CollisionError) Error() string {
+	return fmt.Sprintf("target id collision: %s created by %v", e.Key, e.EventIDs)
+}
+
+// NewTracker returns a Tracker which remembers up to maxOrphans events at a time, the oldest are forgotten first.
+func NewTracker(maxOrphans int) *Tracker {
+	return &Tracker{
+		sessions:       map[string][]Session{},
+		sessionTokens:  map[string]bool{},
+		shared:         map[string]string{},
+		console:        map[string][]string{},
+		identityCenter: map[string]string{},
+		unhandled:      map[string]int{},
+		orphans:        map[string][]orphan{},
+		maxOrphans:     maxOrphans,
+	}
+}
+
+// Observe records the session created by event, if any. Corrections are returned for any orphaned events that were
+// made with the new session.
+//
+// This can be called before the events around it are resolved, so sessions are known as early as possible.
+func (t *Tracker) Observe(event types.AssumeRoleEvent) ([]Correction, error) {
+	if event.EventTime.After(t.latest) {
+		t.latest = event.EventTime
+	}
+
+	// Identity Center events are the only ones with both the user name and ID of its users.
+	if u := event.IdentityCenterUser(); u != nil {
+		t.identityCenter[u.UserName] = u.PrincipalId
+	}
+
+	// TODO: check other event types
+	if event.EventType != "AwsApiCall" && event.EventType != "AwsConsoleSignIn" {
+		return nil, nil
+	}
+
+	target := event.Target()
+	if target == nil {
+		return nil, nil
+	}
+	sess := newSession(t.key(*target), *target, event)
+	if i := event.Identity(); Derived(i) {
+		sess.Parent = t.key(i)
+	}
+	key := sess.Key
+
+	if event.EventName == "SwitchRole" {
+		t.switchedFrom(&sess, event)
+	}
+
+	if event.SharedEventID != "" {
+		if k, ok := t.shared[event.SharedEventID]; ok {
+			// This is the other account's copy of a call we've already seen, upgrade the session if we only had the
+			// target account's copy before.
+			//
+			// Events already written with the session keep the lower confidence attribution.
+			for i, existing := range t.sessions[k] {
+				if existing.SharedEventID == sess.SharedEventID && existing.Confidence == Low && sess.Confidence == High {
+					sess.Key = k
+					t.sessions[k][i] = sess
+					t.created(sess)
+				}
+			}
+			return nil, nil
+		}
+		t.shared[event.SharedEventID] = key
+	}
+
+	if existing, ok := t.sessions[key]; ok {
+		// If we've already seen this event or session token it's likely just a duplicate record.
+		if t.duplicate(existing, event) {
+			return nil, nil
+		}
+
+		// In the case that we haven't, a collision occurred.
+		//
+		// It is possible for two sessions to end up with the same set of identifiable userIdentity records, usually
+		// because the access key was recycled. Keep both so later events can be attributed to one or the other.
+		t.sessions[key] = append(existing, sess)
+		t.created(sess)
+		t.sessionTokens[sess.token] = true
+
+		// Keys recycled after the earlier sessions expired can be told apart by when events were made, so they
+		// aren't reported.
+		if !expiredBy(existing, sess.EventTime) {
+			t.collisions++
+
+			collision := CollisionError{Key: key}
+			for _, s := range t.sessions[key] {
+				collision.EventIDs = append(collision.EventIDs, s.EventID)
+			}
+			return nil, collision
+		}
+	} else {
+		t.sessions[key] = []Session{sess}
+		t.created(sess)
+		t.sessionTokens[sess.token] = true
+		if target.InvokedBy == types.ConsoleInvokedBy {
+			t.console[target.Arn] = append(t.console[target.Arn], key)
+		}
+	}
+
+	var corrections []Correction
+	for _, o := range t.orphans[key] {
+		corrections = append(corrections, Correction{
+			EventID:              o.eventID,
+			SessionKey:           key,
+			OriginalUserIdentity: t.original(sess),
+			Confidence:           sess.Confidence,
+		})
+	}
+	delete(t.orphans, key)
+
+	return corrections, nil
+}
+
+func (t *Tracker) created(sess Session) {
+	if t.OnCreate != nil {
+		sess.Original = t.original(sess)
+		t.OnCreate(sess)
+	}
+}
+
+func (t *Tracker) duplicate(existing []Session, event types.AssumeRoleEvent) bool {
+	for _, s := range existing {
+		if s.EventID != "" && s.EventID == event.EventID {
+			return true
+		}
+	}
+
+	token := sessionToken(event)
+	return token != "" && t.sessionTokens[token]
+}
+
+// Resolve returns the session event was made with.
+//
+// If the session should have been created by an event we haven't seen yet, event is remembered as an orphan and
+// ok is false.
+func (t *Tracker) Resolve(event types.AssumeRoleEvent) (a Attribution, ok bool) {
+	key := t.key(event.Identity())
+
+	candidates, ok := t.sessions[key]
+	if !ok {
+		if Derived(event.Identity()) {
+			t.addOrphan(orphan{key: key, eventID: event.EventID})
+		}
+		return a, false
+	}
+
+	if len(candidates) == 1 {
+		a = Attribution{Session: candidates[0], Confidence: candidates[0].Confidence}
+	} else {
+		a = disambiguate(event, candidates)
+	}
+	a.Session.Original = t.original(a.Session)
+	a.Expired = a.Session.Expired(event.EventTime)
+	return a, true
+}
+
+// Lineage returns the chain of sessions that led to sess, starting with sess itself and ending with the session
+// created by a long-term identity. At most max hops are returned, truncated is true if the chain was longer than that
+// or looped back on itself.
+//
+// The chain also ends early if the creator of a session wasn't seen.
+func (t *Tracker) Lineage(sess Session, max int) (hops []Hop, truncated bool) {
+	seen := map[string]bool{}
+	for {
+		if seen[sess.Key] || len(hops) >= max {
+			return hops, true
+		}
+		seen[sess.Key] = true
+
+		sess.Original = t.original(sess)
+		hops = append(hops, newHop(sess))
+
+		if sess.Parent == "" {
+			return hops, false
+		}
+		parent, ok := t.parent(sess)
+		if !ok {
+			return hops, false
+		}
+		sess = parent
+	}
+}
+
+// Root reports whether sess was created by the root user, directly or through other sessions.
+func (t *Tracker) Root(sess Session) bool {
+	hops, _ := t.Lineage(sess, DefaultLineage)
+	for _, hop := range hops {
+		if hop.Original.Type == "Root" {
+			return true
+		}
+	}
+	return false
+}
+
+// Evict forgets sessions that expired more than grace before the latest event observed, and returns how many were
+// removed. Sessions without an expiration are kept for maxLifetime, the longest any session can last.
+//
+// Events made with evicted sessions can't be attributed, so grace should allow for events delivered late.
+func (t *Tracker) Evict(grace time.Duration) (n int) {
+	before := t.latest.Add(-grace)
+
+	for key, sessions := range t.sessions {
+		var kept []Session
+		for _, s := range sessions {
+			expiration := s.EventTime.Add(maxLifetime)
+			if s.Expiration != nil {
+				expiration = *s.Expiration
+			}
+
+			if expiration.Before(before) {
+				delete(t.sessionTokens, s.token)
+				if s.SharedEventID != "" {
+					delete(t.shared, s.SharedEventID)
+				}
+				n++
+			} else {
+				kept = append(kept, s)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(t.sessions, key)
+		} else {
+			t.sessions[key] = kept
+		}
+	}
+
+	for arn, keys := range t.console {
+		var kept []string
+		for _, key := range keys {
+			if _, ok := t.sessions[key]; ok {
+				kept = append(kept, key)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(t.console, arn)
+		} else {
+			t.console[arn] = kept
+		}
+	}
+	return n
+}
+
+// Len returns the number of sessions being tracked.
+func (t *Tracker) Len() (n int) {
+	for _, sessions := range t.sessions {
+		n += len(sessions)
+	}
+	return n
+}
+
+// parent returns the session that created sess. If the parent's key collided the most recent session created before
+// sess is used.
+func (t *Tracker) parent(sess Session) (Session, bool) {
+	candidates := t.sessions[sess.Parent]
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if !candidates[i].EventTime.After(sess.EventTime) {
+			return candidates[i], true
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[len(candidates)-1], true
+	}
+	return Session{}, false
+}
+
+// original returns the original identity of sess, filling in anything we've learnt about it since the session was
+// created.
+func (t *Tracker) original(sess Session) types.UserIdentity {
+	i := sess.Original
+	if i.Type == types.IdentityCenterUserType && i.PrincipalId == "" {
+		i.PrincipalId = t.identityCenter[i.UserName]
+	}
+	return i
+}
+
+// switchedFrom sets the original identity of a session created by SwitchRole to the console session it was called
+// from.
+//
+// SwitchRole only tells us the ARN of the caller, so if more than one console session has that ARN the one with the
+// closest sourceIPAddress and userAgent is used. If none are known the identity is built from the ARN with Low
+// confidence.
+func (t *Tracker) switchedFrom(sess *Session, event types.AssumeRoleEvent) {
+	var candidates []Session
+	for _, key := range t.console[sess.Original.Arn] {
+		for _, c := range t.sessions[key] {
+			if !c.EventTime.After(event.EventTime) {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		sess.Confidence = Low
+		sess.Parent = ""
+		return
+	case 1:
+		sess.Original = candidates[0].Target
+		sess.Parent = candidates[0].Key
+		return
+	}
+
+	from, ok := closest(event, candidates)
+	sess.Original = from.Target
+	sess.Parent = from.Key
+	if ok {
+		sess.Confidence = lowest(sess.Confidence, Medium)
+	} else {
+		sess.Confidence = Low
+	}
+}
+
+// disambiguate picks the session event was most likely made with out of candidates which all have the same key.
+//
+// If only one candidate was active when the event was made it's used with its own confidence. Otherwise if one
+// candidate is a better match than the rest it's used with Medium confidence, or failing that the most recent session
+// is used with Low confidence.
+func disambiguate(event types.AssumeRoleEvent, candidates []Session) Attribution {
+	var active []Session
+	for _, c := range candidates {
+		if c.Active(event.EventTime) {
+			active = append(active, c)
+		}
+	}
+
+	switch len(active) {
+	case 0:
+		active = candidates
+	case 1:
+		return Attribution{Session: active[0], Confidence: active[0].Confidence, Candidates: candidates}
+	}
+
+	best, ok := closest(event, active)
+
+	a := Attribution{Session: best, Confidence: Low, Candidates: candidates}
+	if ok {
+		a.Confidence = Medium
+	}
+	a.Confidence = lowest(a.Confidence, a.Session.Confidence)
+	return a
+}
+
+// closest returns the candidate whose creating call had the same sourceIPAddress and userAgent as event. If no
+// candidate is a better match than the rest the most recent one is returned and ok is false.
+func closest(event types.AssumeRoleEvent, candidates []Session) (sess Session, ok bool) {
+	best, bestScore, tied := len(candidates)-1, -1, false
+	for i, c := range candidates {
+		score := 0
+		if c.SourceIPAddress != "" && c.SourceIPAddress == event.SourceIPAddress {
+			score += 2
+		}
+		if c.UserAgent != "" && c.UserAgent == event.UserAgent {
+			score += 1
+		}
+
+		if score > bestScore {
+			best, bestScore, tied = i, score, false
+		} else if score == bestScore {
+			tied = true
+		}
+	}
+
+	if bestScore > 0 && !tied {
+		return candidates[best], true
+	}
+	return candidates[len(candidates)-1], false
+}
+
+// Collisions returns the number of times a new session had the same key as an existing one.
+func (t *Tracker) Collisions() int {
+	return t.collisions
+}
+
+// Unhandled returns the number of identities that only got a fallback key, by the reason why. These are identity
+// types or shapes we don't know how to handle yet, events made with them are unlikely to be attributed.
+func (t *Tracker) Unhandled() map[string]int {
+	unhandled := make(map[string]int, len(t.unhandled))
+	for k, v := range t.unhandled {
+		unhandled[k] = v
+	}
+	return unhandled
+}
+
+// key returns the key of i, counting it if i isn't something we know how to handle.
+func (t *Tracker) key(i types.UserIdentity) string {
+	key, err := i.Key()
+	if err != nil {
+		t.unhandled[err.Error()]++
+	}
+	return key
+}
+
+// Orphans returns the number of events waiting on the session they were made with.
+func (t *Tracker) Orphans() (n int) {
+	for _, o := range t.orphans {
+		n += len(o)
+	}
+	return n
+}
+
+func (t *Tracker) addOrphan(o orphan) {
+	t.orphans[o.key] = append(t.orphans[o.key], o)
+	t.orphanList = append(t.orphanList, o)
+
+	for len(t.orphanList) > t.maxOrphans {
+		oldest := t.orphanList[0]
+		t.orphanList = t.orphanList[1:]
+
+		// The oldest orphan may have been resolved already, in which case there's nothing to remove.
+		if pending := t.orphans[oldest.key]; len(pending) > 0 && pending[0] == oldest {
+			if len(pending) == 1 {
+				delete(t.orphans, oldest.key)
+			} else {
+				t.orphans[oldest.key] = pending[1:]
+			}
+		}
+	}
+}
+
+// Derived reports whether i is a session that should have been created by an event we can see, rather than a
+// long-term identity.
+func Derived(i types.UserIdentity) bool {
+	if i.InvokedBy == types.ConsoleInvokedBy {
+		return true
+	}
+
+	switch i.Type {
+	case "AssumedRole", "FederatedUser":
+		return true
+	case "IAMUser", "Root":
+		// GetSessionToken sessions.
+		return i.Temporary() && i.SessionContext != nil
+	}
+	return false
+}
+
+// expiredBy reports whether all of sessions had expired by t.
+func expiredBy(sessions []Session, t time.Time) bool {
+	for _, s := range sessions {
+		if !s.Expired(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func sessionToken(event types.AssumeRoleEvent) string {
+	if event.ResponseElements.Credentials == nil {
+		return ""
+	}
+	return event.ResponseElements.Credentials.SessionToken
+}
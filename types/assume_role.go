@@ -6,10 +6,12 @@ import (
 	"github.com/ryanjarv/sqs/schema/aws/logs/awsapicallviacloudtrail"
 	"hash/maphash"
 	"regexp"
+	"strings"
 	"time"
 )
 
-var ec2PrincipalRe = regexp.MustCompile(`^AROA[A-Z\d]{17}:i-[\da-z]{17}$`)
+// ec2PrincipalRe matches the principal ID of EC2 instance profile sessions, the session name is the instance ID.
+var ec2PrincipalRe = regexp.MustCompile(`^AROA[A-Z\d]{17}:(i-(?:[\da-f]{8}|[\da-f]{17}))$`)
 
 var hash = maphash.Hash{}
 
@@ -107,6 +109,13 @@ type UserIdentity struct {
 	AccountId      string                                  `json:"accountId,omitempty"`
 	AccessKeyId    string                                  `json:"accessKeyId,omitempty"`
 	SessionContext *awsapicallviacloudtrail.SessionContext `json:"sessionContext,omitempty"`
+
+	// IdentityProvider is set for SAMLUser and WebIdentityUser identities, it's the ARN of the SAML provider or the
+	// name of the OIDC provider.
+	IdentityProvider string `json:"identityProvider,omitempty"`
+
+	// OnBehalfOf is set for requests made by IAM Identity Center users.
+	OnBehalfOf *OnBehalfOf `json:"onBehalfOf,omitempty"`
 }
 
 func (i UserIdentity) Group() string {
@@ -121,12 +130,35 @@ func (i UserIdentity) CrossAccount() bool {
 	return utils.In([]string{"AWSAccount", "AWSService"}, i.Type)
 }
 
+// Temporary reports whether the request was made with temporary credentials, which use ASIA access keys.
+func (i UserIdentity) Temporary() bool {
+	return strings.HasPrefix(i.AccessKeyId, "ASIA")
+}
+
+// MfaAuthenticated returns the sessionContext.attributes.mfaAuthenticated attribute, ok is false if the identity
+// doesn't have a session context. This is the case for requests made with long-term access keys.
+func (i UserIdentity) MfaAuthenticated() (mfa bool, ok bool) {
+	if i.SessionContext == nil || i.SessionContext.Attributes.MfaAuthenticated == "" {
+		return false, false
+	}
+	return i.SessionContext.Attributes.MfaAuthenticated == "true", true
+}
+
 type RequestParameters struct {
 	RoleArn         string `json:"roleArn,omitempty"`
 	RoleSessionName string `json:"roleSessionName,omitempty"`
 	ExternalId      string `json:"externalId,omitempty"`
 	DurationSeconds int    `json:"durationSeconds,omitempty"`
 
+	// SerialNumber is the MFA device passed to GetSessionToken or AssumeRole.
+	SerialNumber string `json:"serialNumber,omitempty"`
+
+	// PrincipalArn is the SAML provider passed to AssumeRoleWithSAML.
+	PrincipalArn string `json:"principalArn,omitempty"`
+
+	// SourceIdentity is set by the caller of AssumeRole.
+	SourceIdentity string `json:"sourceIdentity,omitempty"`
+
 	// Policy contains the scoped down IAM policy provided in the request if it is present.
 	// We don't care about it for now so defer processing of this section.
 	Policy *json.RawMessage `json:"policy,omitempty"`
@@ -157,8 +189,50 @@ type ResponseElements struct {
 	AssumedRoleUser *AssumeRoleUser `json:"assumedRoleUser,omitempty"`
 	FederatedUser   *FederatedUser  `json:"federatedUser,omitempty"`
 
+	// ConsoleLogin and SwitchRole are "Success" or "Failure" on AwsConsoleSignIn events.
+	ConsoleLogin string `json:"ConsoleLogin,omitempty"`
+	SwitchRole   string `json:"SwitchRole,omitempty"`
+
 	// PackedPolicySize is only present when a policy as sent in the request.
 	PackedPolicySize *int `json:"packedPolicySize,omitempty"`
+
+	// Subject, SubjectType, Issuer, Audience and NameQualifier are returned by AssumeRoleWithSAML.
+	Subject       string `json:"subject,omitempty"`
+	SubjectType   string `json:"subjectType,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`
+	Audience      string `json:"audience,omitempty"`
+	NameQualifier string `json:"nameQualifier,omitempty"`
+
+	// SubjectFromWebIdentityToken and Provider are returned by AssumeRoleWithWebIdentity, along with Audience.
+	SubjectFromWebIdentityToken string `json:"subjectFromWebIdentityToken,omitempty"`
+	Provider                    string `json:"provider,omitempty"`
+
+	// SourceIdentity is returned by AssumeRoleWithSAML and AssumeRoleWithWebIdentity when the IdP sets one.
+	SourceIdentity string `json:"sourceIdentity,omitempty"`
+}
+
+// Federation describes the external identity that was used to create a session with AssumeRoleWithSAML or
+// AssumeRoleWithWebIdentity.
+type Federation struct {
+	// Provider is the ARN of the SAML or OIDC provider in IAM.
+	Provider string `json:"provider"`
+
+	// Subject identifies the user at the IdP, SubjectType is only set for SAML.
+	Subject     string `json:"subject"`
+	SubjectType string `json:"subjectType,omitempty"`
+
+	Issuer         string `json:"issuer,omitempty"`
+	Audience       string `json:"audience,omitempty"`
+	SourceIdentity string `json:"sourceIdentity,omitempty"`
+}
+
+// AdditionalEventData is only used on AwsConsoleSignIn events so far.
+type AdditionalEventData struct {
+	// SwitchFrom is the ARN of the identity that called SwitchRole.
+	SwitchFrom string `json:"SwitchFrom,omitempty"`
+	RedirectTo string `json:"RedirectTo,omitempty"`
+	LoginTo    string `json:"LoginTo,omitempty"`
+	MFAUsed    string `json:"MFAUsed,omitempty"`
 }
 
 type AssumeRoleEvent struct {
@@ -193,6 +267,11 @@ type AssumeRoleEvent struct {
 	//     * https://docs.aws.amazon.com/awscloudtrail/latest/userguide/non-api-aws-service-events.html
 	EventType string `json:"eventType,omitempty"`
 
+	AdditionalEventData *AdditionalEventData `json:"additionalEventData,omitempty"`
+
+	// SessionCredentialFromConsole is "true" when the call was made from the console.
+	SessionCredentialFromConsole string `json:"sessionCredentialFromConsole,omitempty"`
+
 	ManagementEvent    bool   `json:"managementEvent,omitempty"`
 	RecipientAccountId string `json:"recipientAccountId,omitempty"`
 	SharedEventID      string `json:"sharedEventID,omitempty"`
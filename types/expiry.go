@@ -0,0 +1,53 @@
+package types
+
+import (
+	"time"
+)
+
+// expirationLayouts are the formats CloudTrail uses for credentials.expiration, older events use the same format
+// as the STS console output while newer ones use RFC 3339.
+var expirationLayouts = []string{
+	"Jan 2, 2006, 3:04:05 PM",
+	time.RFC3339,
+}
+
+// defaultDurations are how long sessions last when the caller doesn't pass DurationSeconds.
+var defaultDurations = map[string]time.Duration{
+	"AssumeRole":                time.Hour,
+	"AssumeRoleWithSAML":        time.Hour,
+	"AssumeRoleWithWebIdentity": time.Hour,
+	"GetSessionToken":           12 * time.Hour,
+	"GetFederationToken":        12 * time.Hour,
+}
+
+// ExpirationTime parses the Expiration of the credentials, ok is false if it's missing or in a format we don't know.
+func (c Credentials) ExpirationTime() (t time.Time, ok bool) {
+	for _, layout := range expirationLayouts {
+		t, err := time.Parse(layout, c.Expiration)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Expiration returns when the session created by the event expires, ok is false if it isn't known. This is the
+// expiration of the returned credentials, or when it isn't logged the requested or default duration.
+//
+// Console sessions aren't given an expiration, it depends on how they were signed in to.
+func (e AssumeRoleEvent) Expiration() (t time.Time, ok bool) {
+	if c := e.ResponseElements.Credentials; c != nil {
+		if t, ok := c.ExpirationTime(); ok {
+			return t, true
+		}
+	}
+
+	if e.RequestParameters.DurationSeconds > 0 {
+		return e.EventTime.Add(time.Duration(e.RequestParameters.DurationSeconds) * time.Second), true
+	}
+
+	if d, ok := defaultDurations[e.EventName]; ok {
+		return e.EventTime.Add(d), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+func TestAssumeRoleEvent_Expiration(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  AssumeRoleEvent
+		want   time.Time
+		wantOk bool
+	}{
+		{
+			name: "console format",
+			event: AssumeRoleEvent{
+				EventName:        "AssumeRole",
+				EventTime:        testDate,
+				ResponseElements: ResponseElements{Credentials: &Credentials{Expiration: "Apr 1, 2022, 2:01:01 AM"}},
+			},
+			want:   time.Date(2022, time.April, 1, 2, 1, 1, 0, time.UTC),
+			wantOk: true,
+		},
+		{
+			name: "RFC 3339",
+			event: AssumeRoleEvent{
+				EventName:        "AssumeRole",
+				EventTime:        testDate,
+				ResponseElements: ResponseElements{Credentials: &Credentials{Expiration: "2022-04-01T02:01:01Z"}},
+			},
+			want:   time.Date(2022, time.April, 1, 2, 1, 1, 0, time.UTC),
+			wantOk: true,
+		},
+		{
+			name: "DurationSeconds",
+			event: AssumeRoleEvent{
+				EventName:         "AssumeRole",
+				EventTime:         testDate,
+				RequestParameters: RequestParameters{DurationSeconds: 900},
+				ResponseElements:  ResponseElements{Credentials: &Credentials{}},
+			},
+			want:   testDate.Add(15 * time.Minute),
+			wantOk: true,
+		},
+		{
+			name:   "default",
+			event:  AssumeRoleEvent{EventName: "GetSessionToken", EventTime: testDate},
+			want:   testDate.Add(12 * time.Hour),
+			wantOk: true,
+		},
+		{
+			name:  "console",
+			event: AssumeRoleEvent{EventName: "ConsoleLogin", EventTime: testDate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.event.Expiration()
+			if ok != tt.wantOk {
+				t.Fatalf("Expiration() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Expiration() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
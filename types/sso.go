@@ -0,0 +1,102 @@
+package types
+
+import (
+	"strings"
+)
+
+// IdentityCenterUserType is the identity type used for IAM Identity Center (SSO) users.
+//
+// CloudTrail doesn't have a type for these, in member accounts they show up as a SAMLUser calling
+// AssumeRoleWithSAML on an AWSReservedSSO_* role. Sessions for those roles are attributed to the Identity Center user
+// instead so the lineage is keyed on the human rather than the reserved role.
+const IdentityCenterUserType = "IdentityCenterUser"
+
+// reservedSSORolePrefix is the prefix of the roles Identity Center creates for permission sets.
+const reservedSSORolePrefix = "AWSReservedSSO_"
+
+// ssoProviderPrefix is the prefix of the SAML providers Identity Center creates in member accounts.
+const ssoProviderPrefix = "saml-provider/AWSSSO_"
+
+// OnBehalfOf is set on the identity of requests made by Identity Center users in newer events.
+type OnBehalfOf struct {
+	UserId           string `json:"userId,omitempty"`
+	IdentityStoreArn string `json:"identityStoreArn,omitempty"`
+}
+
+// ReservedSSORole reports whether arn is an AWSReservedSSO_* role or a session for one.
+//
+//	arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_AdministratorAccess_0123456789abcdef
+//	arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_AdministratorAccess_0123456789abcdef/you
+func ReservedSSORole(arn string) bool {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return false
+	}
+
+	resource := strings.Split(parts[5], "/")
+	switch resource[0] {
+	case "role":
+		return strings.HasPrefix(resource[len(resource)-1], reservedSSORolePrefix)
+	case "assumed-role":
+		return len(resource) > 1 && strings.HasPrefix(resource[1], reservedSSORolePrefix)
+	}
+	return false
+}
+
+// IdentityCenter reports whether the event is Identity Center creating a session for one of its users.
+func (e AssumeRoleEvent) IdentityCenter() bool {
+	if e.EventName != "AssumeRoleWithSAML" {
+		return false
+	}
+
+	if strings.Contains(e.UserIdentity.IdentityProvider, ssoProviderPrefix) ||
+		strings.Contains(e.RequestParameters.PrincipalArn, ssoProviderPrefix) {
+		return true
+	}
+	return e.ResponseElements.AssumedRoleUser != nil && ReservedSSORole(e.ResponseElements.AssumedRoleUser.Arn)
+}
+
+// IdentityCenterUser returns the Identity Center user that made the request for events logged by Identity Center
+// itself, such as sso:Federate and GetRoleCredentials. These are logged in the account Identity Center is managed
+// from and are the only place we see the user ID.
+func (e AssumeRoleEvent) IdentityCenterUser() *UserIdentity {
+	switch e.EventSource {
+	case "sso.amazonaws.com", "portal.sso.amazonaws.com":
+	default:
+		return nil
+	}
+
+	i := &UserIdentity{
+		Type:      IdentityCenterUserType,
+		UserName:  e.UserIdentity.UserName,
+		AccountId: e.UserIdentity.AccountId,
+	}
+
+	switch {
+	case e.UserIdentity.OnBehalfOf != nil:
+		i.PrincipalId = e.UserIdentity.OnBehalfOf.UserId
+	case e.UserIdentity.Type == "Unknown" || e.UserIdentity.Type == IdentityCenterUserType:
+		// Older events put the user ID in the principal ID.
+		i.PrincipalId = e.UserIdentity.PrincipalId
+	}
+
+	if i.UserName == "" || i.PrincipalId == "" {
+		return nil
+	}
+	return i
+}
+
+// identityCenterSource returns the Identity Center user that an IdentityCenter AssumeRoleWithSAML call was made for.
+// Only the user name is known from the member account, the ID is filled in by the session tracker if it has seen the
+// user in an Identity Center event.
+func (e AssumeRoleEvent) identityCenterSource() UserIdentity {
+	name := e.UserIdentity.UserName
+	if name == "" {
+		name = e.ResponseElements.Subject
+	}
+	return UserIdentity{
+		Type:             IdentityCenterUserType,
+		UserName:         name,
+		IdentityProvider: e.UserIdentity.IdentityProvider,
+	}
+}
@@ -0,0 +1,126 @@
+package types
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestReservedSSORole(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want bool
+	}{
+		{arn: "arn:aws:iam::111111111111:role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_AdministratorAccess_0123456789abcdef", want: true},
+		{arn: "arn:aws:sts::111111111111:assumed-role/AWSReservedSSO_AdministratorAccess_0123456789abcdef/test", want: true},
+		{arn: "arn:aws:sts::111111111111:assumed-role/AdministratorAccess/AWSReservedSSO_test", want: false},
+		{arn: "arn:aws:iam::111111111111:user/AWSReservedSSO_test", want: false},
+		{arn: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			if got := ReservedSSORole(tt.arn); got != tt.want {
+				t.Errorf("ReservedSSORole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleEvent_Source_IdentityCenter(t *testing.T) {
+	event := AssumeRoleEvent{
+		EventName: "AssumeRoleWithSAML",
+		UserIdentity: UserIdentity{
+			Type:             "SAMLUser",
+			PrincipalId:      "W8CpC/mVXoV4aUsMy2iWIv+8lZQ=:test",
+			UserName:         "test",
+			IdentityProvider: "arn:aws:iam::111111111111:saml-provider/AWSSSO_0123456789abcdef_DO_NOT_DELETE",
+		},
+		ResponseElements: ResponseElements{
+			AssumedRoleUser: &AssumeRoleUser{
+				Arn: "arn:aws:sts::111111111111:assumed-role/AWSReservedSSO_AdministratorAccess_0123456789abcdef/test",
+			},
+		},
+	}
+
+	want := UserIdentity{
+		Type:             IdentityCenterUserType,
+		UserName:         "test",
+		IdentityProvider: "arn:aws:iam::111111111111:saml-provider/AWSSSO_0123456789abcdef_DO_NOT_DELETE",
+	}
+	if diff := cmp.Diff(want, event.Source()); diff != "" {
+		t.Errorf("Source() mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := want.Id(), "IdentityCenterUser:test"; got != want {
+		t.Errorf("Id() = %v, want %v", got, want)
+	}
+
+	// Other SAML providers keep the SAMLUser.
+	event.UserIdentity.IdentityProvider = "arn:aws:iam::111111111111:saml-provider/Okta"
+	event.ResponseElements.AssumedRoleUser.Arn = "arn:aws:sts::111111111111:assumed-role/okta-admin/test"
+	if diff := cmp.Diff(event.UserIdentity, event.Source()); diff != "" {
+		t.Errorf("Source() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAssumeRoleEvent_IdentityCenterUser(t *testing.T) {
+	tests := []struct {
+		name  string
+		event AssumeRoleEvent
+		want  *UserIdentity
+	}{
+		{
+			name: "Federate",
+			event: AssumeRoleEvent{
+				EventSource: "sso.amazonaws.com",
+				EventName:   "Federate",
+				UserIdentity: UserIdentity{
+					Type:        "Unknown",
+					PrincipalId: "11111111-1111-1111-1111-111111111111",
+					AccountId:   "111111111111",
+					UserName:    "test",
+				},
+			},
+			want: &UserIdentity{
+				Type:        IdentityCenterUserType,
+				PrincipalId: "11111111-1111-1111-1111-111111111111",
+				AccountId:   "111111111111",
+				UserName:    "test",
+			},
+		},
+		{
+			name: "GetRoleCredentials",
+			event: AssumeRoleEvent{
+				EventSource: "portal.sso.amazonaws.com",
+				EventName:   "GetRoleCredentials",
+				UserIdentity: UserIdentity{
+					Type:      IdentityCenterUserType,
+					AccountId: "111111111111",
+					UserName:  "test",
+					OnBehalfOf: &OnBehalfOf{
+						UserId:           "11111111-1111-1111-1111-111111111111",
+						IdentityStoreArn: "arn:aws:identitystore::111111111111:identitystore/d-1111111111",
+					},
+				},
+			},
+			want: &UserIdentity{
+				Type:        IdentityCenterUserType,
+				PrincipalId: "11111111-1111-1111-1111-111111111111",
+				AccountId:   "111111111111",
+				UserName:    "test",
+			},
+		},
+		{
+			name: "not Identity Center",
+			event: AssumeRoleEvent{
+				EventSource:  "sts.amazonaws.com",
+				UserIdentity: UserIdentity{Type: "Unknown", PrincipalId: "test", UserName: "test"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tt.event.IdentityCenterUser()); diff != "" {
+				t.Errorf("IdentityCenterUser() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Workload types, these are the AWS resources that service-issued sessions can be traced back to.
+const (
+	WorkloadEC2       = "EC2Instance"
+	WorkloadLambda    = "LambdaFunction"
+	WorkloadECS       = "ECSTask"
+	WorkloadCodeBuild = "CodeBuild"
+)
+
+// workloadServices maps the service principals that create sessions for workloads to the type of workload.
+var workloadServices = map[string]string{
+	"ec2.amazonaws.com":       WorkloadEC2,
+	"lambda.amazonaws.com":    WorkloadLambda,
+	"ecs-tasks.amazonaws.com": WorkloadECS,
+	"codebuild.amazonaws.com": WorkloadCodeBuild,
+}
+
+// codeBuildSessionPrefix is the prefix of the session names CodeBuild uses, it's followed by the build ID.
+const codeBuildSessionPrefix = "AWSCodeBuild-"
+
+// execEnvRe matches the execution environment Lambda and ECS add to the user agent of the AWS SDKs.
+//
+//	aws-sdk-go/1.44.0 (go1.18; linux; amd64) exec-env/AWS_Lambda_go1.x
+//	Boto3/1.24.0 Python/3.9.13 Linux/4.14.255 exec-env/AWS_ECS_FARGATE Botocore/1.27.0
+var execEnvRe = regexp.MustCompile(`exec-env/AWS_(Lambda|ECS)_`)
+
+// Workload is the AWS resource a session was issued to, such as an EC2 instance or Lambda function.
+type Workload struct {
+	// Type is one of WorkloadEC2, WorkloadLambda, WorkloadECS or WorkloadCodeBuild.
+	Type string `json:"type"`
+
+	// ResourceId is the instance ID, function name, task ID or build ID of the workload.
+	ResourceId string `json:"resourceId"`
+}
+
+// Workload returns the workload a session was created for when an AWS service called AssumeRole on its behalf, it's
+// nil for everything else.
+//
+// The role session name is the ID of the workload for each of the services we know about.
+func (e AssumeRoleEvent) Workload() *Workload {
+	if e.EventName != "AssumeRole" || e.UserIdentity.Type != "AWSService" {
+		return nil
+	}
+
+	typ, ok := workloadServices[e.UserIdentity.InvokedBy]
+	name := e.RequestParameters.RoleSessionName
+	if !ok || name == "" {
+		return nil
+	}
+
+	if typ == WorkloadCodeBuild {
+		name = strings.TrimPrefix(name, codeBuildSessionPrefix)
+	}
+	return &Workload{Type: typ, ResourceId: name}
+}
+
+// Workload returns the workload an identity belongs to if it can be told from the identity alone. This only works
+// for EC2 instance profiles and CodeBuild, which use a recognisable session name.
+func (i UserIdentity) Workload() *Workload {
+	if i.Type != "AssumedRole" {
+		return nil
+	}
+
+	if m := ec2PrincipalRe.FindStringSubmatch(i.PrincipalId); m != nil {
+		return &Workload{Type: WorkloadEC2, ResourceId: m[1]}
+	}
+
+	name := sessionName(i.PrincipalId)
+	if strings.HasPrefix(name, codeBuildSessionPrefix) {
+		return &Workload{Type: WorkloadCodeBuild, ResourceId: strings.TrimPrefix(name, codeBuildSessionPrefix)}
+	}
+	return nil
+}
+
+// CallerWorkload returns the workload that made the call. Lambda functions and ECS tasks are recognised by the
+// execution environment in the user agent, since their session names aren't distinctive.
+//
+// The session tracker knows the workload of sessions it saw created, this is for when it didn't.
+func (e AssumeRoleEvent) CallerWorkload() *Workload {
+	if w := e.UserIdentity.Workload(); w != nil {
+		return w
+	}
+
+	name := sessionName(e.UserIdentity.PrincipalId)
+	if e.UserIdentity.Type != "AssumedRole" || name == "" {
+		return nil
+	}
+
+	switch m := execEnvRe.FindStringSubmatch(e.UserAgent); {
+	case m == nil:
+		return nil
+	case m[1] == "Lambda":
+		return &Workload{Type: WorkloadLambda, ResourceId: name}
+	default:
+		return &Workload{Type: WorkloadECS, ResourceId: name}
+	}
+}
+
+// sessionName returns the role session name from the principal ID of a role session.
+//
+//	AROAXXXXXXXXXXXXXXXXX:session-name
+func sessionName(principalId string) string {
+	parts := strings.SplitN(principalId, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[1]
+}
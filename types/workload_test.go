@@ -0,0 +1,115 @@
+package types
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestAssumeRoleEvent_Workload(t *testing.T) {
+	serviceAssumeRole := func(invokedBy, sessionName string) AssumeRoleEvent {
+		return AssumeRoleEvent{
+			EventName:         "AssumeRole",
+			UserIdentity:      UserIdentity{Type: "AWSService", InvokedBy: invokedBy},
+			RequestParameters: RequestParameters{RoleSessionName: sessionName},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		event AssumeRoleEvent
+		want  *Workload
+	}{
+		{
+			name:  "EC2",
+			event: serviceAssumeRole("ec2.amazonaws.com", "i-0123456789abcdef0"),
+			want:  &Workload{Type: WorkloadEC2, ResourceId: "i-0123456789abcdef0"},
+		},
+		{
+			name:  "Lambda",
+			event: serviceAssumeRole("lambda.amazonaws.com", "my-function"),
+			want:  &Workload{Type: WorkloadLambda, ResourceId: "my-function"},
+		},
+		{
+			name:  "ECS",
+			event: serviceAssumeRole("ecs-tasks.amazonaws.com", "0123456789abcdef0123456789abcdef"),
+			want:  &Workload{Type: WorkloadECS, ResourceId: "0123456789abcdef0123456789abcdef"},
+		},
+		{
+			name:  "CodeBuild",
+			event: serviceAssumeRole("codebuild.amazonaws.com", "AWSCodeBuild-01234567-89ab-cdef-0123-456789abcdef"),
+			want:  &Workload{Type: WorkloadCodeBuild, ResourceId: "01234567-89ab-cdef-0123-456789abcdef"},
+		},
+		{
+			name:  "other service",
+			event: serviceAssumeRole("codepipeline.amazonaws.com", "1648774861000"),
+		},
+		{
+			name: "not a service",
+			event: AssumeRoleEvent{
+				EventName:         "AssumeRole",
+				UserIdentity:      UserIdentity{Type: "IAMUser"},
+				RequestParameters: RequestParameters{RoleSessionName: "i-0123456789abcdef0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tt.event.Workload()); diff != "" {
+				t.Errorf("Workload() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleEvent_CallerWorkload(t *testing.T) {
+	tests := []struct {
+		name        string
+		principalId string
+		userAgent   string
+		want        *Workload
+	}{
+		{
+			name:        "EC2",
+			principalId: "AROA11111111111111111:i-0123456789abcdef0",
+			want:        &Workload{Type: WorkloadEC2, ResourceId: "i-0123456789abcdef0"},
+		},
+		{
+			name:        "EC2 short instance ID",
+			principalId: "AROA11111111111111111:i-01234567",
+			want:        &Workload{Type: WorkloadEC2, ResourceId: "i-01234567"},
+		},
+		{
+			name:        "CodeBuild",
+			principalId: "AROA11111111111111111:AWSCodeBuild-01234567-89ab-cdef-0123-456789abcdef",
+			want:        &Workload{Type: WorkloadCodeBuild, ResourceId: "01234567-89ab-cdef-0123-456789abcdef"},
+		},
+		{
+			name:        "Lambda",
+			principalId: "AROA11111111111111111:my-function",
+			userAgent:   "aws-sdk-go/1.44.0 (go1.18; linux; amd64) exec-env/AWS_Lambda_go1.x",
+			want:        &Workload{Type: WorkloadLambda, ResourceId: "my-function"},
+		},
+		{
+			name:        "ECS",
+			principalId: "AROA11111111111111111:0123456789abcdef0123456789abcdef",
+			userAgent:   "Boto3/1.24.0 Python/3.9.13 Linux/4.14.255 exec-env/AWS_ECS_FARGATE Botocore/1.27.0",
+			want:        &Workload{Type: WorkloadECS, ResourceId: "0123456789abcdef0123456789abcdef"},
+		},
+		{
+			name:        "user session",
+			principalId: "AROA11111111111111111:you",
+			userAgent:   "aws-cli/2.7.0 Python/3.9.11 Darwin/21.5.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := AssumeRoleEvent{
+				UserIdentity: UserIdentity{Type: "AssumedRole", PrincipalId: tt.principalId},
+				UserAgent:    tt.userAgent,
+			}
+			if diff := cmp.Diff(tt.want, event.CallerWorkload()); diff != "" {
+				t.Errorf("CallerWorkload() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}